@@ -0,0 +1,389 @@
+package command
+
+// コンテナやプレフィックスをアーカイブとしてダウンロードする(--archive)
+//
+// オブジェクトを一つずつ取得して、ディスクに保存せずにそのままtar(tar.gz)やzipに書き込む。
+// 更新日時とパーミッションはメタデータ(なければLast-Modified)から、
+// ディレクトリを表すオブジェクトはディレクトリのエントリとして書き込む。
+// 復号や展開、ETagによる検証は通常のダウンロードと同じく行う。
+// 書き込みを始めたオブジェクトで失敗した場合は、アーカイブが壊れるため中断する。
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"github.com/hironobu-s/conoha-ojs/lib"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ARCHIVE_TAR    = "tar"
+	ARCHIVE_TAR_GZ = "tar.gz"
+	ARCHIVE_ZIP    = "zip"
+)
+
+func validArchiveFormat(format string) error {
+	switch format {
+	case ARCHIVE_TAR, ARCHIVE_TAR_GZ, ARCHIVE_ZIP:
+		return nil
+	}
+	return errors.New(fmt.Sprintf("Invalid --archive \"%s\". It must be tar, tar.gz or zip.", format))
+}
+
+// tarとzipの違いを吸収する
+type archiveWriter interface {
+	// ディレクトリのエントリを書き込む
+	WriteDir(name string, mtime time.Time) error
+
+	// ファイルのエントリを書き込み、データを書き込むWriterを返す
+	// サイズがわからない場合は負の値を指定する
+	WriteFile(name string, size int64, mode os.FileMode, mtime time.Time) (io.Writer, error)
+
+	Close() error
+}
+
+func newArchiveWriter(format string, w io.Writer) archiveWriter {
+	switch format {
+	case ARCHIVE_ZIP:
+		return &zipArchive{zw: zip.NewWriter(w)}
+
+	case ARCHIVE_TAR_GZ:
+		gw := gzip.NewWriter(w)
+		return &tarArchive{tw: tar.NewWriter(gw), gw: gw}
+
+	default:
+		return &tarArchive{tw: tar.NewWriter(w)}
+	}
+}
+
+type tarArchive struct {
+	tw *tar.Writer
+	gw *gzip.Writer
+}
+
+func (a *tarArchive) WriteDir(name string, mtime time.Time) error {
+	return a.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     strings.TrimSuffix(name, "/") + "/",
+		Mode:     0755,
+		ModTime:  mtime,
+	})
+}
+
+func (a *tarArchive) WriteFile(name string, size int64, mode os.FileMode, mtime time.Time) (io.Writer, error) {
+	// tarはエントリの前にサイズを書き込むため、サイズがわからないデータは書き込めない
+	if size < 0 {
+		return nil, errors.New("The size of the data is unknown. It can not be added to a tar archive. Use --archive zip instead.")
+	}
+
+	err := a.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     int64(mode.Perm()),
+		ModTime:  mtime,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a.tw, nil
+}
+
+func (a *tarArchive) Close() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	if a.gw != nil {
+		return a.gw.Close()
+	}
+	return nil
+}
+
+type zipArchive struct {
+	zw *zip.Writer
+}
+
+func (a *zipArchive) WriteDir(name string, mtime time.Time) error {
+	hdr := &zip.FileHeader{
+		Name:     strings.TrimSuffix(name, "/") + "/",
+		Modified: mtime,
+	}
+	hdr.SetMode(os.ModeDir | 0755)
+
+	_, err := a.zw.CreateHeader(hdr)
+	return err
+}
+
+func (a *zipArchive) WriteFile(name string, size int64, mode os.FileMode, mtime time.Time) (io.Writer, error) {
+	hdr := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: mtime,
+	}
+	hdr.SetMode(mode.Perm())
+
+	return a.zw.CreateHeader(hdr)
+}
+
+func (a *zipArchive) Close() error {
+	return a.zw.Close()
+}
+
+// オブジェクトをアーカイブに書き込んでダウンロードする
+// destpathが"-"の場合は標準出力に書き込む
+func (cmd *Download) downloadArchive(srcpath string, destpath string) (err error) {
+	log := lib.GetLogInstance()
+
+	if fi, err := os.Stat(destpath); err == nil && fi.IsDir() {
+		return errors.New(fmt.Sprintf("%s is a directory. Specify a file name or \"-\" for --archive.", destpath))
+	}
+
+	// 対象の情報を取得
+	// オブジェクトが存在しない場合は、プレフィックスとして扱う
+	item, container, prefix, err := cmd.statOrPrefix(srcpath, true)
+	if err != nil {
+		return err
+	}
+
+	var entries []ObjectEntry
+	if object, ok := item.(*Object); ok && prefix == "" {
+		// 単一のオブジェクトはファイル名だけのエントリにする
+		var name string
+		container, name = splitPath(srcpath)
+		name = strings.Trim(name, "/")
+		if dir := path.Dir(name); dir != "." {
+			cmd.basePrefix = container + "/" + dir + "/"
+		} else {
+			cmd.basePrefix = container + "/"
+		}
+		entries = []ObjectEntry{{Name: name, Bytes: int64(object.ContentLength), ContentType: object.ContentType}}
+
+	} else {
+		if _, isContainer := item.(*Container); isContainer {
+			container = strings.Trim(srcpath, "/")
+		} else {
+			// プレフィックスからの相対パスにする
+			cmd.basePrefix = container + "/" + prefix
+		}
+
+		l := NewCommand("list", cmd.config, cmd.stdStream, cmd.errStream).(*List)
+		entries, err = l.ListDetail(container, prefix)
+		if err != nil {
+			return err
+		}
+
+		if prefix != "" && item == nil && len(entries) == 0 {
+			return errNotFound
+		}
+	}
+
+	// ディレクトリを表すオブジェクトは進捗に含めない
+	var total int64
+	files := 0
+	for _, entry := range entries {
+		if !isDirectoryMarker(entry.Name, entry.ContentType) {
+			total += entry.Bytes
+			files++
+		}
+	}
+	cmd.progress.SetTotal(files, total)
+
+	// 出力先を準備する
+	// ファイルの場合は.partに書き込んで、最後に置き換える
+	var out io.Writer = cmd.stdStream
+	var file *os.File
+	partpath := destpath + PART_SUFFIX
+	if destpath != STDOUT_FILENAME {
+		file, err = os.Create(partpath)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			file.Close()
+			if err != nil {
+				os.Remove(partpath)
+			}
+		}()
+		out = file
+	}
+
+	bw := bufio.NewWriter(out)
+	aw := newArchiveWriter(cmd.archive, bw)
+
+	for _, entry := range entries {
+		name := container + "/" + entry.Name
+
+		u, err := buildStorageUrl(cmd.config.EndPointUrl, name)
+		if err != nil {
+			return err
+		}
+
+		if isDirectoryMarker(entry.Name, entry.ContentType) {
+			// --flattenの場合と、プレフィックス自体を表すオブジェクトはエントリを作成しない
+			if cmd.flatten || entry.Name == prefix {
+				continue
+			}
+
+			_, safe, err := cmd.objectPath(u)
+			if err == nil {
+				err = aw.WriteDir(safe, parseListingTime(entry.LastModified))
+			}
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		written, err := cmd.archiveObject(aw, u)
+		cmd.progress.Done(err)
+		if err != nil && !written {
+			// エントリを書き込む前のエラーは、そのオブジェクトを飛ばして続ける
+			log.Warnf("%s: %v", name, err)
+			continue
+
+		} else if err != nil {
+			return errors.New(fmt.Sprintf("%s: %v The archive is incomplete.", name, err))
+		}
+		log.Infof("%s was added to the archive.", name)
+	}
+
+	if err = aw.Close(); err != nil {
+		return err
+	}
+	if err = bw.Flush(); err != nil {
+		return err
+	}
+
+	if file != nil {
+		if err = file.Sync(); err != nil {
+			return err
+		}
+		file.Close()
+
+		if err = cmd.replaceFile(partpath, destpath, nil); err != nil {
+			return err
+		}
+	}
+
+	if n := cmd.progress.Failures(); n > 0 {
+		return errors.New(fmt.Sprintf("%d objects failed to download.", n))
+	}
+
+	return nil
+}
+
+// オブジェクトを取得してアーカイブに書き込む
+// writtenはアーカイブにエントリを書き込み始めたか(失敗した場合にアーカイブが壊れているか)
+func (cmd *Download) archiveObject(aw archiveWriter, u *url.URL) (written bool, err error) {
+	_, safe, err := cmd.objectPath(u)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := cmd.get(u, nil)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	err = responseError(resp)
+	if err != nil {
+		return false, err
+	}
+
+	cmd.progress.Start(path.Base(u.Path), resp.ContentLength)
+
+	body, v, raw, err := cmd.decodeBody(u, resp)
+	if err != nil {
+		return false, err
+	}
+
+	size, err := cmd.decodedSize(resp, raw)
+	if err != nil {
+		return false, err
+	}
+
+	mode := os.FileMode(0644)
+	if s := resp.Header.Get(META_MODE); s != "" {
+		if m, err := parseMode(s); err == nil {
+			mode = m
+		}
+	}
+
+	mtime := time.Now()
+	if s := resp.Header.Get(META_MTIME); s != "" {
+		if t, err := parseMtime(s); err == nil {
+			mtime = t
+		}
+	} else if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		mtime = t
+	}
+
+	w, err := aw.WriteFile(safe, size, mode, mtime)
+	if err != nil {
+		return false, err
+	}
+
+	if v != nil {
+		w = io.MultiWriter(w, v)
+	}
+
+	n, err := io.Copy(w, body)
+	if err != nil {
+		return true, err
+	}
+	if size >= 0 && n != size {
+		return true, io.ErrUnexpectedEOF
+	}
+
+	if v != nil {
+		if err = v.Verify(); err != nil {
+			return true, err
+		}
+	}
+
+	return true, nil
+}
+
+// 復号や展開をした後のデータのサイズを返す
+// わからない場合は-1を返す
+func (cmd *Download) decodedSize(resp *http.Response, raw bool) (int64, error) {
+	if raw {
+		return resp.ContentLength, nil
+	}
+
+	// 展開する場合は、アップロード時に記録した元のサイズ
+	if cmd.decompress && strings.ToLower(resp.Header.Get("Content-Encoding")) == "gzip" {
+		size, err := strconv.ParseInt(resp.Header.Get(META_ORIGINAL_SIZE), 10, 64)
+		if err != nil {
+			return -1, nil
+		}
+		return size, nil
+	}
+
+	params, err := cryptoParamsFromHeader(resp.Header)
+	if err != nil || params == nil || resp.ContentLength < 0 {
+		return -1, err
+	}
+	return params.PlainSize(resp.ContentLength)
+}
+
+// コンテナの一覧のlast_modified(UTC)を読み込む
+// 読み込めない場合は現在時刻を返す
+func parseListingTime(s string) time.Time {
+	t, err := time.Parse("2006-01-02T15:04:05.999999", s)
+	if err != nil {
+		return time.Now()
+	}
+	return t
+}
@@ -0,0 +1,67 @@
+package command
+
+// ダウンロードしたファイルの置き換え
+//
+// データは同じディレクトリの一時ファイル(.partなど)に書き込み、fsyncして検証してから
+// rename(2)で保存先に置き換える。失敗した場合は、元のファイルはそのまま残る。
+// --backupを指定した場合は、置き換える前のファイルを<ファイル名><--backup-suffix>に残す。
+
+import (
+	"github.com/hironobu-s/conoha-ojs/lib"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// バックアップのファイル名に付ける文字列のデフォルト
+const DEFAULT_BACKUP_SUFFIX = "~"
+
+// 書き込みが終わった一時ファイルで保存先を置き換える
+// headerのメタデータから更新日時などを復元してから置き換える
+func (cmd *Download) replaceFile(tmppath string, localpath string, header http.Header) (err error) {
+	log := lib.GetLogInstance()
+
+	if header != nil {
+		restoreAttributes(tmppath, header)
+	}
+
+	// 置き換える前のファイルを残す
+	backup := ""
+	if cmd.backup {
+		if fi, err := os.Lstat(localpath); err == nil && fi.Mode().IsRegular() {
+			backup = localpath + cmd.backupSuffix
+			err = os.Rename(localpath, backup)
+			if err != nil {
+				return err
+			}
+			log.Infof("%s was backed up to %s.", localpath, backup)
+		}
+	}
+
+	err = os.Rename(tmppath, localpath)
+	if err != nil {
+		// バックアップしたファイルを元に戻す
+		if backup != "" {
+			os.Rename(backup, localpath)
+		}
+		return err
+	}
+
+	syncDir(filepath.Dir(localpath))
+
+	return nil
+}
+
+// ファイル名の変更を確定するため、ディレクトリをfsyncする
+// 対応していない環境(Windowsなど)ではエラーを無視する
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+
+	if err = d.Sync(); err != nil {
+		lib.GetLogInstance().Debugf("Cannot sync the directory %s. [%v]", dir, err)
+	}
+}
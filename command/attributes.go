@@ -0,0 +1,123 @@
+package command
+
+// ファイルの更新日時とパーミッションの保存と復元
+//
+// アップロード時にpython-swiftclientと同じくX-Object-Meta-Mtimeに更新日時を記録し、
+// --preserve-modeを指定した場合はX-Object-Meta-Modeにパーミッションを記録する。
+// ダウンロード時は記録されている値をファイルに設定する。
+
+import (
+	"errors"
+	"fmt"
+	"github.com/hironobu-s/conoha-ojs/lib"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 更新日時とパーミッションを記録するメタデータ
+const (
+	META_MTIME = "X-Object-Meta-Mtime"
+	META_MODE  = "X-Object-Meta-Mode"
+)
+
+// メタデータに記録するmtimeの形式(python-swiftclientと同じく、小数点以下6桁のUNIX時間)
+func formatMtime(t time.Time) string {
+	return fmt.Sprintf("%d.%06d", t.Unix(), t.Nanosecond()/1000)
+}
+
+// メタデータに記録されたmtimeを時刻に変換する
+func parseMtime(s string) (time.Time, error) {
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return time.Time{}, errors.New(fmt.Sprintf("Invalid mtime in the metadata. [%s]", s))
+	}
+
+	sec := int64(f)
+	usec := int64((f-float64(sec))*1e6 + 0.5)
+	return time.Unix(sec, usec*1000), nil
+}
+
+// パーミッションは8進数で記録する
+func formatMode(mode os.FileMode) string {
+	return fmt.Sprintf("%04o", mode.Perm())
+}
+
+func parseMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(strings.TrimSpace(s), 8, 32)
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("Invalid mode in the metadata. [%s]", s))
+	}
+	return os.FileMode(mode).Perm(), nil
+}
+
+// ファイルの更新日時とパーミッションをメタデータにする
+func (cmd *Upload) attributesHeader(fi os.FileInfo) http.Header {
+	header := http.Header{}
+	header.Set(META_MTIME, formatMtime(fi.ModTime()))
+
+	if cmd.preserveMode {
+		header.Set(META_MODE, formatMode(fi.Mode()))
+	}
+	return header
+}
+
+// ヘッダをまとめる
+// 同じ名前のヘッダは後のものを使う
+func mergeHeader(headers ...http.Header) http.Header {
+	merged := http.Header{}
+	for _, header := range headers {
+		for name, values := range header {
+			merged[name] = values
+		}
+	}
+	return merged
+}
+
+// メタデータの更新日時とパーミッションがファイルと一致するか調べる
+// 更新日時が記録されていなければokはfalseになる
+func (cmd *Upload) sameAttributes(metadatas map[string]string, fi os.FileInfo) (same bool, ok bool) {
+	mtime, ok := metadatas[META_MTIME]
+	if !ok {
+		return false, false
+	}
+
+	if mtime != formatMtime(fi.ModTime()) {
+		return false, true
+	}
+
+	// パーミッションを記録する場合は、変更されていればアップロードし直す
+	if cmd.preserveMode && metadatas[META_MODE] != formatMode(fi.Mode()) {
+		return false, true
+	}
+
+	return true, true
+}
+
+// メタデータに記録された更新日時とパーミッションをファイルに設定する
+// 設定できなくてもダウンロードは失敗にしない
+func restoreAttributes(path string, header http.Header) {
+	log := lib.GetLogInstance()
+
+	if s := header.Get(META_MODE); s != "" {
+		mode, err := parseMode(s)
+		if err == nil {
+			err = os.Chmod(path, mode)
+		}
+		if err != nil {
+			log.Infof("Cannot set the mode of %s. [%v]", path, err)
+		}
+	}
+
+	if s := header.Get(META_MTIME); s != "" {
+		mtime, err := parseMtime(s)
+		if err == nil {
+			err = os.Chtimes(path, time.Now(), mtime)
+		}
+		if err != nil {
+			log.Infof("Cannot set the modification time of %s. [%v]", path, err)
+		}
+	}
+}
@@ -0,0 +1,322 @@
+package command
+
+// Swiftのbulkミドルウェア(extract-archive)による一括アップロード
+//
+// 通常のファイルをtarにまとめて、一回のリクエストで複数のオブジェクトを作成する。
+// ディレクトリ、シンボリックリンク(preserve)、セグメントサイズより大きいファイルは
+// 通常どおり一つずつアップロードする。
+// Content-Typeとメタデータは、bulkミドルウェアが対応しているtarの拡張属性で指定する。
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hironobu-s/conoha-ojs/lib"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	BULK_FORMAT_TAR    = "tar"
+	BULK_FORMAT_TAR_GZ = "tar.gz"
+
+	// 一回のリクエストでアップロードするファイルの最大数
+	BULK_UPLOAD_MAX_FILES = 1000
+)
+
+// 一括アップロードするファイル
+type bulkFile struct {
+	path   string
+	object string
+	info   os.FileInfo
+}
+
+// bulkミドルウェア(extract-archive, bulk-delete)の結果
+type bulkResult struct {
+	NumberFilesCreated int        `json:"Number Files Created"`
+	NumberDeleted      int        `json:"Number Deleted"`
+	NumberNotFound     int        `json:"Number Not Found"`
+	ResponseStatus     string     `json:"Response Status"`
+	ResponseBody       string     `json:"Response Body"`
+	Errors             [][]string `json:"Errors"`
+}
+
+// 一括アップロードできるファイルか調べる
+func (cmd *Upload) bulkable(info os.FileInfo) bool {
+	if !info.Mode().IsRegular() {
+		return false
+	}
+	return cmd.segmentSize <= 0 || info.Size() <= int64(cmd.segmentSize)
+}
+
+// 溜めておいたファイルを一括アップロードする
+// 一部のファイルが失敗しても残りのアップロードを続ける
+func (cmd *Upload) flush_bulk() (err error) {
+	log := lib.GetLogInstance()
+
+	files := []bulkFile{}
+	for _, file := range cmd.bulkFiles {
+		if cmd.skipIdentical && cmd.isIdentical(file.path, file.object, file.info) {
+			log.Infof("%s is not changed. skipped.", file.path)
+			cmd.skipped++
+			cmd.progress.SkipFile(file.info.Size())
+			continue
+		}
+		files = append(files, file)
+	}
+	cmd.bulkFiles = nil
+
+	failures := 0
+	for len(files) > 0 {
+		n := len(files)
+		if n > BULK_UPLOAD_MAX_FILES {
+			n = BULK_UPLOAD_MAX_FILES
+		}
+
+		failed, err := cmd.request_bulk(files[:n])
+		if err != nil {
+			return err
+		}
+		failures += failed
+
+		files = files[n:]
+	}
+
+	if failures > 0 {
+		return errors.New(fmt.Sprintf("%d files failed to upload.", failures))
+	}
+	return nil
+}
+
+// ファイルをtarにまとめてextract-archiveにアップロードする
+// 失敗したファイルの数を返す
+func (cmd *Upload) request_bulk(files []bulkFile) (failed int, err error) {
+	log := lib.GetLogInstance()
+
+	uri, err := buildStorageUrl(cmd.config.EndPointUrl, cmd.destContainer)
+	if err != nil {
+		return 0, err
+	}
+	uri.RawQuery = "extract-archive=" + cmd.bulkFormat
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(cmd.writeArchive(pw, files))
+	}()
+	defer pr.Close()
+
+	req, err := http.NewRequest("PUT", uri.String(), pr)
+	if err != nil {
+		return 0, err
+	}
+	req.ContentLength = -1
+
+	req.Header.Set("X-Auth-Token", cmd.config.Token)
+	req.Header.Set("Accept", "application/json")
+
+	log.Debugf("Uploading %d files with extract-archive.", len(files))
+
+	// 進捗はまとめたファイル全体で表示して、ファイルごとの結果は応答を読み込んでから数える
+	var size int64
+	for _, file := range files {
+		size += file.info.Size()
+	}
+	cmd.progress.Start(fmt.Sprintf("%d files (bulk)", len(files)), size)
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == 404:
+		return 0, errors.New("Container was not found.")
+
+	case resp.StatusCode >= 400:
+		msg := fmt.Sprintf("Return %d status code from the server with message. [%s].",
+			resp.StatusCode,
+			extractErrorMessage(resp.Body),
+		)
+		return 0, errors.New(msg)
+	}
+
+	result, err := parseBulkResult(resp)
+	if err != nil {
+		return 0, err
+	}
+
+	// ファイルごとのエラー
+	// オブジェクト名はURLエンコードされていて、先頭の"/"は付かない場合もあるので取り除いて比べる
+	errs := map[string]string{}
+	for _, e := range result.Errors {
+		if len(e) != 2 {
+			continue
+		}
+		name, err := url.PathUnescape(e[0])
+		if err != nil {
+			name = e[0]
+		}
+		errs[strings.TrimPrefix(name, "/")] = e[1]
+	}
+
+	// エラーがなく、ステータスが失敗の場合はリクエスト全体が失敗している
+	if len(errs) == 0 && !strings.HasPrefix(result.ResponseStatus, "2") {
+		return 0, errors.New(fmt.Sprintf("Bulk upload failed. [%s %s]", result.ResponseStatus, result.ResponseBody))
+	}
+
+	for _, file := range files {
+		status, ok := errs[strings.TrimPrefix(cmd.destContainer+"/"+file.object, "/")]
+		if ok {
+			log.Warnf("%s upload error. [%s]", file.path, status)
+			cmd.progress.Done(errors.New(status))
+			failed++
+			continue
+		}
+
+		log.Infof("%s was uploaded. (bulk)", file.path)
+		cmd.progress.Done(nil)
+		cmd.uploaded++
+	}
+
+	return failed, nil
+}
+
+// ファイルをtar(またはtar.gz)にまとめて書き込む
+func (cmd *Upload) writeArchive(w io.Writer, files []bulkFile) (err error) {
+	bw := bufio.NewWriter(w)
+
+	var out io.Writer = bw
+	var zw *gzip.Writer
+	if cmd.bulkFormat == BULK_FORMAT_TAR_GZ {
+		zw = gzip.NewWriter(bw)
+		out = zw
+	}
+
+	tw := tar.NewWriter(out)
+	for _, file := range files {
+		err = cmd.writeArchiveEntry(tw, file)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	if zw != nil {
+		if err = zw.Close(); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// tarにファイルを一つ書き込む
+// Content-Typeとメタデータはbulkミドルウェアが読み込む拡張属性にする
+func (cmd *Upload) writeArchiveEntry(tw *tar.Writer, file bulkFile) (err error) {
+	f, err := os.Open(file.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	records := map[string]string{
+		"SCHILY.xattr.user.mime_type":  cmd.detectFileContentType(file.path),
+		"SCHILY.xattr.user.meta.mtime": formatMtime(file.info.ModTime()),
+	}
+	if cmd.preserveMode {
+		records["SCHILY.xattr.user.meta.mode"] = formatMode(file.info.Mode())
+	}
+	for name, value := range cmd.metadatas {
+		records["SCHILY.xattr.user.meta."+name] = value
+	}
+
+	hdr := &tar.Header{
+		Typeflag:   tar.TypeReg,
+		Name:       file.object,
+		Size:       file.info.Size(),
+		Mode:       int64(file.info.Mode().Perm()),
+		ModTime:    file.info.ModTime(),
+		PAXRecords: records,
+		Format:     tar.FormatPAX,
+	}
+
+	if err = tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	// 書き込み中にファイルのサイズが変わると、tarが壊れるのでエラーにする
+	written, err := io.Copy(tw, cmd.progress.Reader(io.LimitReader(f, file.info.Size())))
+	if err != nil {
+		return err
+	}
+	if written != file.info.Size() {
+		return errors.New(fmt.Sprintf("%s was changed while uploading.", file.path))
+	}
+
+	return nil
+}
+
+// bulkミドルウェアの結果を読み込む
+// JSONでない場合は "Key: Value" 形式のテキストとして読み込む
+func parseBulkResult(resp *http.Response) (result *bulkResult, err error) {
+	result = &bulkResult{}
+
+	if strings.Contains(resp.Header.Get("Content-Type"), "json") {
+		err = json.NewDecoder(resp.Body).Decode(result)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Invalid response from the bulk middleware. [%v]", err))
+		}
+		return result, nil
+	}
+
+	inErrors := false
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		// Errors: 以降の行は "オブジェクト, ステータス"
+		if inErrors {
+			i := strings.LastIndex(line, ", ")
+			if i >= 0 {
+				result.Errors = append(result.Errors, []string{line[:i], line[i+2:]})
+				continue
+			}
+		}
+
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(kv[1])
+
+		switch kv[0] {
+		case "Number Files Created":
+			result.NumberFilesCreated, _ = strconv.Atoi(value)
+		case "Number Deleted":
+			result.NumberDeleted, _ = strconv.Atoi(value)
+		case "Number Not Found":
+			result.NumberNotFound, _ = strconv.Atoi(value)
+		case "Response Status":
+			result.ResponseStatus = value
+		case "Response Body":
+			result.ResponseBody = value
+		case "Errors":
+			inErrors = true
+		}
+	}
+
+	return result, scanner.Err()
+}
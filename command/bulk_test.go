@@ -0,0 +1,62 @@
+package command
+
+import (
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseBulkResult(t *testing.T) {
+	tests := []struct {
+		contentType string
+		body        string
+	}{
+		{
+			"application/json; charset=utf-8",
+			`{"Number Files Created": 1, "Number Deleted": 2, "Number Not Found": 3,` +
+				` "Response Status": "400 Bad Request", "Response Body": "",` +
+				` "Errors": [["c/a%20b.txt", "413 Request Entity Too Large"], ["/c/d.txt", "401 Unauthorized"]]}`,
+		},
+		{
+			"text/plain; charset=utf-8",
+			"Number Files Created: 1\n" +
+				"Number Deleted: 2\n" +
+				"Number Not Found: 3\n" +
+				"Response Status: 400 Bad Request\n" +
+				"Response Body: \n" +
+				"Errors:\n" +
+				"c/a%20b.txt, 413 Request Entity Too Large\n" +
+				"/c/d.txt, 401 Unauthorized\n",
+		},
+	}
+
+	want := &bulkResult{
+		NumberFilesCreated: 1,
+		NumberDeleted:      2,
+		NumberNotFound:     3,
+		ResponseStatus:     "400 Bad Request",
+		Errors: [][]string{
+			{"c/a%20b.txt", "413 Request Entity Too Large"},
+			{"/c/d.txt", "401 Unauthorized"},
+		},
+	}
+
+	for _, test := range tests {
+		resp := &http.Response{
+			Header: http.Header{"Content-Type": {test.contentType}},
+			Body:   ioutil.NopCloser(strings.NewReader(test.body)),
+		}
+
+		result, err := parseBulkResult(resp)
+		if err != nil {
+			t.Errorf("%s: %v", test.contentType, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(result, want) {
+			t.Errorf("%s: got %+v, want %+v", test.contentType, result, want)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package command
+
+// オブジェクトの一括削除
+//
+// クラスタがbulkミドルウェアのbulk-deleteに対応している場合は、
+// 削除するオブジェクトのパスを改行で区切って、max_deletes_per_requestずつPOSTする。
+// 対応していない場合は、DELETE_CONCURRENCYの並列数で一つずつ削除する。
+
+import (
+	"errors"
+	"fmt"
+	"github.com/hironobu-s/conoha-ojs/lib"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const (
+	// 一回のリクエストで削除するオブジェクトの最大数(クラスタの設定がない場合)
+	BULK_DELETE_MAX_OBJECTS = 10000
+
+	// 一つずつ削除する場合の並列数
+	DELETE_CONCURRENCY = 10
+)
+
+// 削除した結果
+type deleteReport struct {
+	deleted  int
+	notFound int
+	failed   int
+}
+
+// コンテナ内のオブジェクトをまとめて削除する
+// 失敗したオブジェクトがあっても続け、最後に結果を表示する
+func (cmd *Delete) deleteObjects(container string, names []string) (err error) {
+	log := lib.GetLogInstance()
+
+	report := &deleteReport{}
+
+	if max, ok := cmd.bulkDeleteLimit(); ok {
+		for len(names) > 0 {
+			n := len(names)
+			if n > max {
+				n = max
+			}
+
+			err = cmd.request_bulk_delete(container, names[:n], report)
+			if err != nil {
+				return err
+			}
+
+			names = names[n:]
+		}
+
+	} else {
+		cmd.deleteConcurrently(container, names, report)
+	}
+
+	log.Infof("%d objects were deleted, %d objects were not found, %d objects failed to delete.",
+		report.deleted, report.notFound, report.failed)
+
+	if report.failed > 0 {
+		return errors.New(fmt.Sprintf("%d objects failed to delete.", report.failed))
+	}
+	return nil
+}
+
+// bulk-deleteで一回に削除できるオブジェクトの数を返す
+// クラスタが対応していない場合はfalseを返す
+func (cmd *Delete) bulkDeleteLimit() (max int, ok bool) {
+	log := lib.GetLogInstance()
+
+	caps, err := cmd.getCapabilities()
+	if err != nil {
+		log.Debugf("Cannot get capabilities of the cluster. [%v]", err)
+		return 0, false
+	}
+
+	if !caps.Has("bulk_delete") {
+		log.Debugf("bulk-delete is not supported by the cluster. Objects are deleted one by one.")
+		return 0, false
+	}
+
+	conf := struct {
+		MaxDeletesPerRequest int `json:"max_deletes_per_request"`
+	}{}
+	if err = caps.Get("bulk_delete", &conf); err != nil || conf.MaxDeletesPerRequest <= 0 {
+		return BULK_DELETE_MAX_OBJECTS, true
+	}
+	return conf.MaxDeletesPerRequest, true
+}
+
+// bulk-deleteでオブジェクトを削除して、結果をreportに加える
+func (cmd *Delete) request_bulk_delete(container string, names []string, report *deleteReport) (err error) {
+	log := lib.GetLogInstance()
+
+	uri, err := url.Parse(cmd.config.EndPointUrl)
+	if err != nil {
+		return err
+	}
+	uri.RawQuery = "bulk-delete"
+
+	// パスはURLエンコードして改行で区切る
+	paths := make([]string, len(names))
+	for i, name := range names {
+		paths[i] = (&url.URL{Path: "/" + container + "/" + name}).EscapedPath()
+	}
+
+	req, err := http.NewRequest("POST", uri.String(), strings.NewReader(strings.Join(paths, "\n")))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("X-Auth-Token", cmd.config.Token)
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("Accept", "application/json")
+
+	log.Debugf("Deleting %d objects with bulk-delete.", len(names))
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == 404:
+		return errors.New("Container was not found.")
+
+	case resp.StatusCode >= 400:
+		msg := fmt.Sprintf("Return %d status code from the server with message. [%s].",
+			resp.StatusCode,
+			extractErrorMessage(resp.Body),
+		)
+		return errors.New(msg)
+	}
+
+	result, err := parseBulkResult(resp)
+	if err != nil {
+		return err
+	}
+
+	// エラーがなく、ステータスが失敗の場合はリクエスト全体が失敗している
+	if len(result.Errors) == 0 && !strings.HasPrefix(result.ResponseStatus, "2") {
+		return errors.New(fmt.Sprintf("Bulk delete failed. [%s %s]", result.ResponseStatus, result.ResponseBody))
+	}
+
+	// オブジェクトごとのエラー
+	// オブジェクト名はURLエンコードされている
+	for _, e := range result.Errors {
+		if len(e) != 2 {
+			continue
+		}
+		name, err := url.PathUnescape(e[0])
+		if err != nil {
+			name = e[0]
+		}
+		log.Warnf("%s delete error. [%s]", strings.TrimPrefix(name, "/"), e[1])
+		report.failed++
+	}
+
+	log.Infof("%d objects were deleted. (bulk)", result.NumberDeleted)
+
+	report.deleted += result.NumberDeleted
+	report.notFound += result.NumberNotFound
+
+	return nil
+}
+
+// オブジェクトを並列に一つずつ削除して、結果をreportに加える
+func (cmd *Delete) deleteConcurrently(container string, names []string, report *deleteReport) {
+	log := lib.GetLogInstance()
+
+	queue := make(chan string)
+	go func() {
+		for _, name := range names {
+			queue <- name
+		}
+		close(queue)
+	}()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+
+	for i := 0; i < DELETE_CONCURRENCY; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for name := range queue {
+				path := container + "/" + name
+
+				u, err := buildStorageUrl(cmd.config.EndPointUrl, path)
+				if err == nil {
+					err = cmd.request(u)
+				}
+
+				mu.Lock()
+				switch {
+				case err == errNotFound:
+					log.Infof("%s was not found.", path)
+					report.notFound++
+				case err != nil:
+					log.Warnf("%s: %v", path, err)
+					report.failed++
+				default:
+					log.Infof("%s was deleted.", path)
+					report.deleted++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,98 @@
+package command
+
+// 変更されていないオブジェクトをダウンロードしない(--changed)
+//
+// ローカルのファイルがある場合は、HEADでオブジェクトの種類を確認して比較の方法を決める。
+//   - データをそのまま保存するオブジェクトは、ファイルのMD5をIf-None-Matchに指定して条件付きでリクエストする
+//   - 暗号化や圧縮(--decompress)されたオブジェクト、ラージオブジェクトはETagがファイルのMD5と一致しないので、
+//     メタデータの更新日時(X-Object-Meta-Mtime)とファイルの更新日時を比べる
+//   - 更新日時が記録されていない場合は、ファイルの更新日時をIf-Modified-Sinceに指定する
+// 304が返された場合や更新日時が一致した場合は最新とみなして、ファイルを書き換えない。
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// オブジェクトが変更されていないため、ダウンロードしなかった
+var errNotModified = errors.New("The object is not modified.")
+
+// 条件付きリクエストのヘッダを返す
+// ローカルのファイルがない場合はnilを返す
+// リクエストしなくても変更されていないとわかる場合は、errNotModifiedを返す
+func (cmd *Download) conditionalHeader(u *url.URL, localpath string) (http.Header, error) {
+	fi, err := os.Stat(localpath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if !fi.Mode().IsRegular() {
+		return nil, nil
+	}
+
+	head, err := cmd.head(u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	header := http.Header{}
+
+	comparable, err := cmd.etagComparable(head.Header)
+	if err != nil {
+		return nil, err
+	}
+
+	if comparable {
+		sum, err := md5File(localpath)
+		if err != nil {
+			return nil, err
+		}
+
+		header.Set("If-None-Match", "\""+sum+"\"")
+		return header, nil
+	}
+
+	if mtime := head.Header.Get(META_MTIME); mtime != "" {
+		if mtime == formatMtime(fi.ModTime()) {
+			return nil, errNotModified
+		}
+		return nil, nil
+	}
+
+	header.Set("If-Modified-Since", fi.ModTime().UTC().Format(http.TimeFormat))
+	return header, nil
+}
+
+// オブジェクトのETagが、保存するファイルのMD5と比較できるか調べる
+func (cmd *Download) etagComparable(header http.Header) (bool, error) {
+	if strings.ToLower(header.Get("X-Static-Large-Object")) == "true" || header.Get("X-Object-Manifest") != "" {
+		return false, nil
+	}
+
+	params, err := cryptoParamsFromHeader(header)
+	if err != nil {
+		return false, err
+	}
+	if params != nil && !cmd.noDecrypt {
+		return false, nil
+	}
+
+	if cmd.decompress && strings.ToLower(header.Get("Content-Encoding")) == "gzip" {
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// 変更されていないファイルを処理済みにする
+func (cmd *Download) notModified(localpath string) error {
+	if fi, err := os.Stat(localpath); err == nil {
+		cmd.progress.SkipFile(fi.Size())
+	}
+	return errNotModified
+}
@@ -0,0 +1,233 @@
+package command
+
+// ローカルファイルとオブジェクトを比較するためのハッシュ計算と、
+// アップロード/ダウンロードしたデータの検証
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+)
+
+// ファイルのMD5を16進数の文字列で返す
+func md5File(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := md5.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// ファイルをセグメントに分割してアップロードした場合の、マニフェストのETagを返す
+// Static Large ObjectのETagは、各セグメントのMD5を連結した文字列のMD5になる
+func md5FileSegmented(filename string, segmentSize int64) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	manifest := md5.New()
+	for {
+		hash := md5.New()
+		n, err := io.CopyN(hash, file, segmentSize)
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+
+		if n > 0 {
+			io.WriteString(manifest, hex.EncodeToString(hash.Sum(nil)))
+		}
+
+		if n < segmentSize {
+			break
+		}
+	}
+
+	return hex.EncodeToString(manifest.Sum(nil)), nil
+}
+
+// 計算したMD5とサーバが返したETagを比較する
+// ラージオブジェクトのETagはダブルクォートで囲まれているので取り除いて比較する
+func verifyEtag(sum string, etag string) error {
+	etag = strings.Trim(etag, `"`)
+	if sum != etag {
+		return errors.New(fmt.Sprintf("ETag mismatch. The data may be corrupted. (local: %s, server: %s)", sum, etag))
+	}
+	return nil
+}
+
+// 受信したデータを書き込んで、最後にVerify()で検証する
+type verifier interface {
+	io.Writer
+	Verify() error
+}
+
+// 通常のオブジェクトはETagがMD5になる
+type etagVerifier struct {
+	etag string
+	hash hash.Hash
+}
+
+func newEtagVerifier(etag string) *etagVerifier {
+	return &etagVerifier{
+		etag: etag,
+		hash: md5.New(),
+	}
+}
+
+func (v *etagVerifier) Write(p []byte) (int, error) {
+	return v.hash.Write(p)
+}
+
+func (v *etagVerifier) Verify() error {
+	return verifyEtag(hex.EncodeToString(v.hash.Sum(nil)), v.etag)
+}
+
+// multipart-manifest=get で取得できるマニフェストの各セグメント
+type sloManifestEntry struct {
+	Name  string `json:"name"`
+	Hash  string `json:"hash"`
+	Bytes int64  `json:"bytes"`
+}
+
+// Static Large Objectはセグメントごとにマニフェストのハッシュと比較する
+// ETagは各セグメントのハッシュを連結したもののMD5になる
+type sloVerifier struct {
+	etag     string
+	segments []sloManifestEntry
+
+	// 書き込み中のセグメント
+	index   int
+	written int64
+	hash    hash.Hash
+
+	err error
+}
+
+func newSloVerifier(etag string, segments []sloManifestEntry) *sloVerifier {
+	return &sloVerifier{
+		etag:     etag,
+		segments: segments,
+		hash:     md5.New(),
+	}
+}
+
+func (v *sloVerifier) Write(p []byte) (int, error) {
+	n := len(p)
+
+	for len(p) > 0 && v.err == nil {
+		if v.index >= len(v.segments) {
+			v.err = errors.New("The data is larger than the total size of segments.")
+			break
+		}
+
+		// 現在のセグメントの残りサイズ分だけハッシュに書き込む
+		rest := v.segments[v.index].Bytes - v.written
+		chunk := p
+		if int64(len(chunk)) > rest {
+			chunk = chunk[:rest]
+		}
+
+		v.hash.Write(chunk)
+		v.written += int64(len(chunk))
+		p = p[len(chunk):]
+
+		if v.written == v.segments[v.index].Bytes {
+			v.finishSegment()
+		}
+	}
+
+	return n, nil
+}
+
+// セグメントのハッシュを比較して、次のセグメントに進む
+func (v *sloVerifier) finishSegment() {
+	segment := v.segments[v.index]
+
+	err := verifyEtag(hex.EncodeToString(v.hash.Sum(nil)), segment.Hash)
+	if err != nil {
+		v.err = errors.New(fmt.Sprintf("Segment %s is corrupted. %v", segment.Name, err))
+	}
+
+	v.index++
+	v.written = 0
+	v.hash = md5.New()
+}
+
+func (v *sloVerifier) Verify() error {
+	// サイズ0のセグメントは書き込みが発生しないので、ここで確認する
+	for v.err == nil && v.index < len(v.segments) && v.written == v.segments[v.index].Bytes {
+		v.finishSegment()
+	}
+
+	if v.err != nil {
+		return v.err
+	}
+
+	if v.index < len(v.segments) {
+		return errors.New("The data is smaller than the total size of segments.")
+	}
+
+	// マニフェストのETagも確認する
+	manifest := md5.New()
+	for _, segment := range v.segments {
+		io.WriteString(manifest, strings.Trim(segment.Hash, `"`))
+	}
+
+	return verifyEtag(hex.EncodeToString(manifest.Sum(nil)), v.etag)
+}
+
+// 圧縮や暗号化されたオブジェクトを展開(復号)して保存する場合の検証
+// 展開前のデータはETagと、展開後のデータはメタデータに記録された元のMD5と比較する
+type decodedVerifier struct {
+	// 展開前のデータを検証する(展開前のデータを別に書き込んでおく)
+	raw verifier
+
+	md5  string
+	hash hash.Hash
+}
+
+func newDecodedVerifier(raw verifier, md5sum string) *decodedVerifier {
+	return &decodedVerifier{
+		raw:  raw,
+		md5:  md5sum,
+		hash: md5.New(),
+	}
+}
+
+func (v *decodedVerifier) Write(p []byte) (int, error) {
+	return v.hash.Write(p)
+}
+
+func (v *decodedVerifier) Verify() error {
+	if v.raw != nil {
+		if err := v.raw.Verify(); err != nil {
+			return err
+		}
+	}
+
+	// 元のMD5が記録されていない場合は展開後のデータを検証しない
+	if v.md5 == "" {
+		return nil
+	}
+
+	sum := hex.EncodeToString(v.hash.Sum(nil))
+	if sum != v.md5 {
+		return errors.New(fmt.Sprintf("MD5 mismatch after decompression. The data may be corrupted. (local: %s, original: %s)", sum, v.md5))
+	}
+	return nil
+}
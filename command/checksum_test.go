@@ -0,0 +1,46 @@
+package command
+
+import (
+	"testing"
+)
+
+func TestSloVerifier(t *testing.T) {
+	// "hello " と "world" の2つのセグメント
+	// マニフェストのETagは各セグメントのMD5を連結した文字列のMD5
+	segments := []sloManifestEntry{
+		{Name: "c_segments/obj/000001", Hash: "f814893777bcc2295fff05f00e508da6", Bytes: 6},
+		{Name: "c_segments/obj/000002", Hash: "7d793037a0760186574b0282f2f435e7", Bytes: 5},
+	}
+	etag := `"a9241ba5acd28b215123d94a556f0dcc"`
+
+	tests := []struct {
+		data   []string
+		etag   string
+		verify bool
+	}{
+		// セグメントの境界をまたいで書き込む
+		{[]string{"hel", "lo wo", "rld"}, etag, true},
+		{[]string{"hello world"}, etag, true},
+		// セグメントのデータが壊れている
+		{[]string{"hello World"}, etag, false},
+		// マニフェストのETagが一致しない
+		{[]string{"hello world"}, `"00000000000000000000000000000000"`, false},
+		// サイズが足りない、多すぎる
+		{[]string{"hello worl"}, etag, false},
+		{[]string{"hello world!"}, etag, false},
+	}
+
+	for _, test := range tests {
+		v := newSloVerifier(test.etag, segments)
+		for _, data := range test.data {
+			v.Write([]byte(data))
+		}
+
+		err := v.Verify()
+		if test.verify && err != nil {
+			t.Errorf("%v should be verified, but %v", test.data, err)
+		} else if !test.verify && err == nil {
+			t.Errorf("%v should not be verified", test.data)
+		}
+	}
+}
@@ -58,6 +58,10 @@ func NewCommand(action string, config *lib.Config, stdStream io.Writer, errStrea
 		cmd = &Post{Command: command}
 	case "delete":
 		cmd = &Delete{Command: command}
+	case "expire":
+		cmd = &Expire{Command: command}
+	case "fix-content-types":
+		cmd = &FixContentTypes{Command: command}
 	case "deauth":
 		cmd = &Deauth{Command: command}
 	case "version":
@@ -0,0 +1,181 @@
+package command
+
+// gzipで圧縮しながらアップロードする
+//
+// オブジェクトにはContent-Encoding: gzipを付け、Content-Typeは元のデータのものにする。
+// 元のデータのサイズとMD5はメタデータに記録し、ダウンロード時の展開後の検証に使う。
+
+import (
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/hironobu-s/conoha-ojs/lib"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// 圧縮するContent-Typeのデフォルト
+const DEFAULT_GZIP_TYPES = "text/*,application/json,application/javascript,application/xml,image/svg+xml"
+
+// 元のデータのサイズとMD5を記録するメタデータ
+const (
+	META_ORIGINAL_SIZE = "X-Object-Meta-Original-Size"
+	META_ORIGINAL_MD5  = "X-Object-Meta-Original-Md5"
+)
+
+// Content-Typeが圧縮の対象か調べる
+// パターンは","区切りで、"text/*"のようにワイルドカードを使える
+func (cmd *Upload) shouldGzip(contentType string) bool {
+	mediaType := strings.TrimSpace(strings.Split(contentType, ";")[0])
+
+	for _, pattern := range strings.Split(cmd.gzipTypes, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+
+		if matched, _ := path.Match(pattern, mediaType); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// ファイルを圧縮しながらアップロードする
+// 元のファイルのサイズとMD5は、アップロードの前に計算してメタデータに付ける
+func (cmd *Upload) request_gzip(filename string, object string, fi os.FileInfo, contentType string) (err error) {
+
+	sum, err := md5File(filename)
+	if err != nil {
+		return err
+	}
+
+	header := cmd.attributesHeader(fi)
+	header.Set("Content-Encoding", "gzip")
+	header.Set(META_ORIGINAL_SIZE, strconv.FormatInt(fi.Size(), 10))
+	header.Set(META_ORIGINAL_MD5, sum)
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// 進捗はファイルのサイズと合うように、圧縮する前のデータで数える
+	reader := gzipReader(cmd.progress.SourceReader(file))
+	defer reader.Close()
+
+	return cmd.request_stream(reader, object, contentType, header)
+}
+
+// Readerの内容を圧縮しながらアップロードする
+// 元のデータのサイズとMD5は最後まで読み込むまでわからないので、最後のPUT(オブジェクトかマニフェスト)に付ける
+// チャンク転送の場合は、アップロードが終わってからPOSTで付ける
+func (cmd *Upload) request_gzip_stream(reader io.Reader, object string, contentType string) (err error) {
+
+	hash := md5.New()
+	counter := &countingWriter{}
+
+	compressed := gzipReader(io.TeeReader(reader, io.MultiWriter(hash, counter)))
+	defer compressed.Close()
+
+	header := http.Header{}
+	header.Set("Content-Encoding", "gzip")
+
+	// 後からPOSTする場合も同じ有効期限になるように、X-Delete-Afterを最初に時刻にしておく
+	header, err = cmd.withDeleteAt(header)
+	if err != nil {
+		return err
+	}
+
+	original := func(h http.Header) {
+		h.Set(META_ORIGINAL_SIZE, strconv.FormatInt(counter.n, 10))
+		h.Set(META_ORIGINAL_MD5, hex.EncodeToString(hash.Sum(nil)))
+	}
+
+	finalized, err := cmd.request_stream_final(compressed, object, contentType, header, original)
+	if err != nil || finalized {
+		return err
+	}
+
+	original(header)
+	return cmd.request_metadata(object, contentType, header)
+}
+
+// POSTでメタデータを付ける
+// POSTするとメタデータが置き換えられるので、引数で指定されたメタデータとヘッダも送信する
+func (cmd *Upload) request_metadata(object string, contentType string, header http.Header) (err error) {
+
+	uri, err := buildStorageUrl(cmd.config.EndPointUrl, cmd.destContainer, object)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", uri.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-type", contentType)
+	req.Header.Set("X-Auth-Token", cmd.config.Token)
+
+	cmd.addHeaders(req, header)
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == 404:
+		return errors.New("Object was not found.")
+
+	case resp.StatusCode >= 400:
+		msg := fmt.Sprintf("Return %d status code from the server with message. [%s].",
+			resp.StatusCode,
+			extractErrorMessage(resp.Body),
+		)
+		return errors.New(msg)
+	}
+
+	log := lib.GetLogInstance()
+	log.Debugf("Set meta data of %s after uploading.", object)
+
+	return nil
+}
+
+// Readerの内容をgzipで圧縮しながら読み込むReaderを返す
+// 途中で読み込みをやめる場合も、Closeすると圧縮処理が終了する
+func gzipReader(reader io.Reader) io.ReadCloser {
+	pr, pw := io.Pipe()
+
+	go func() {
+		zw := gzip.NewWriter(pw)
+
+		_, err := io.Copy(zw, reader)
+		if err == nil {
+			err = zw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	return pr
+}
+
+// 書き込まれたバイト数を数える
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
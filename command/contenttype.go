@@ -0,0 +1,161 @@
+package command
+
+// Content-Typeの判定
+//
+// 次の順に判定し、最初に見つかったものを使う。
+//   1. 設定ファイルのMimeTypesFileに記述された対応表
+//   2. 組み込みの対応表
+//   3. mime.TypeByExtension (OSのmime.typesなどに依存する)
+//   4. データの先頭部分による判定(http.DetectContentType)
+//
+// mime.TypeByExtensionは実行する環境によって結果が変わるため、
+// よく使われる拡張子は組み込みの対応表で固定している。
+
+import (
+	"github.com/hironobu-s/conoha-ojs/lib"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// 判定できなかった場合のContent-Type
+const DEFAULT_CONTENT_TYPE = "application/octet-stream"
+
+// データの判定に使う先頭部分のサイズ
+const SNIFF_LENGTH = 512
+
+// 組み込みの拡張子とMIMEタイプの対応表
+var builtinMimeTypes = lib.MimeTypes{
+	".html":  "text/html; charset=utf-8",
+	".htm":   "text/html; charset=utf-8",
+	".css":   "text/css; charset=utf-8",
+	".js":    "application/javascript",
+	".json":  "application/json",
+	".xml":   "text/xml; charset=utf-8",
+	".txt":   "text/plain; charset=utf-8",
+	".log":   "text/plain; charset=utf-8",
+	".csv":   "text/csv; charset=utf-8",
+	".md":    "text/markdown; charset=utf-8",
+	".yml":   "text/yaml; charset=utf-8",
+	".yaml":  "text/yaml; charset=utf-8",
+	".svg":   "image/svg+xml",
+	".png":   "image/png",
+	".jpg":   "image/jpeg",
+	".jpeg":  "image/jpeg",
+	".gif":   "image/gif",
+	".webp":  "image/webp",
+	".ico":   "image/x-icon",
+	".bmp":   "image/bmp",
+	".tif":   "image/tiff",
+	".tiff":  "image/tiff",
+	".pdf":   "application/pdf",
+	".zip":   "application/zip",
+	".gz":    "application/gzip",
+	".tgz":   "application/gzip",
+	".tar":   "application/x-tar",
+	".bz2":   "application/x-bzip2",
+	".xz":    "application/x-xz",
+	".7z":    "application/x-7z-compressed",
+	".mp3":   "audio/mpeg",
+	".m4a":   "audio/mp4",
+	".wav":   "audio/wav",
+	".ogg":   "audio/ogg",
+	".mp4":   "video/mp4",
+	".webm":  "video/webm",
+	".mov":   "video/quicktime",
+	".avi":   "video/x-msvideo",
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+	".ttf":   "font/ttf",
+	".otf":   "font/otf",
+	".eot":   "application/vnd.ms-fontobject",
+	".wasm":  "application/wasm",
+	".doc":   "application/msword",
+	".docx":  "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".xls":   "application/vnd.ms-excel",
+	".xlsx":  "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".ppt":   "application/vnd.ms-powerpoint",
+	".pptx":  "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+}
+
+type contentTypeDetector struct {
+	// ユーザーが定義した対応表
+	userTypes lib.MimeTypes
+}
+
+// 設定ファイルにMimeTypesFileが指定されていれば読み込む
+func newContentTypeDetector(config *lib.Config) (*contentTypeDetector, error) {
+	d := &contentTypeDetector{}
+
+	if config.MimeTypesFile != "" {
+		types, err := lib.ReadMimeTypes(config.MimeTypesFile)
+		if err != nil {
+			return nil, err
+		}
+		d.userTypes = types
+	}
+
+	return d, nil
+}
+
+// ファイル名の拡張子からContent-Typeを判定する
+// 判定できない場合は空文字を返す
+func (d *contentTypeDetector) ByName(name string) string {
+	ext := filepath.Ext(name)
+	if ext == "" {
+		return ""
+	}
+
+	if contentType := d.userTypes.TypeByExtension(ext); contentType != "" {
+		return contentType
+	}
+
+	if contentType := builtinMimeTypes.TypeByExtension(ext); contentType != "" {
+		return contentType
+	}
+
+	return mime.TypeByExtension(ext)
+}
+
+// ファイル名とデータの先頭部分からContent-Typeを判定する
+// 判定できない場合は空文字を返す
+func (d *contentTypeDetector) Detect(name string, head []byte) string {
+	if contentType := d.ByName(name); contentType != "" {
+		return contentType
+	}
+
+	return sniffContentType(head)
+}
+
+// データの先頭部分からContent-Typeを判定する
+// 判定できない場合は空文字を返す
+func sniffContentType(head []byte) string {
+	if len(head) == 0 {
+		return ""
+	}
+
+	contentType := http.DetectContentType(head)
+	if contentType == DEFAULT_CONTENT_TYPE {
+		return ""
+	}
+	return contentType
+}
+
+// ファイルの先頭部分を読み込む
+func readHead(filename string) ([]byte, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	head := make([]byte, SNIFF_LENGTH)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+
+	return head[:n], nil
+}
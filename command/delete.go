@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strings"
 )
 
 type Delete struct {
@@ -47,8 +48,14 @@ func (cmd *Delete) Usage() {
 Delete a container or objects within a container.
 
 <object_name> Name of object to delete.
+              If no object has the name, all objects beginning with "<name>/"
+              (a pseudo-directory) are deleted.
 
-`, lib.COMMAND_NAME)
+Objects in a container or a pseudo-directory are deleted with the bulk-delete
+middleware if the cluster supports it, otherwise %d objects are deleted
+concurrently. The numbers of deleted, not found and failed objects are shown.
+
+`, lib.COMMAND_NAME, DELETE_CONCURRENCY)
 }
 
 func (cmd *Delete) Run() (exitCode int, err error) {
@@ -71,12 +78,16 @@ func (cmd *Delete) Delete(path string) error {
 	log := lib.GetLogInstance()
 
 	// 対象の情報を取得
-	s := NewCommand("stat", cmd.config, cmd.stdStream, cmd.errStream).(*Stat)
-	item, err := s.Stat(path)
+	// オブジェクトが存在しない場合は、プレフィックスとして扱う
+	item, container, prefix, err := cmd.statOrPrefix(path, false)
 	if err != nil {
 		return err
 	}
 
+	if prefix != "" {
+		return cmd.deletePrefix(container, prefix)
+	}
+
 	_, isContainer := item.(*Container)
 
 	if isContainer {
@@ -87,8 +98,12 @@ func (cmd *Delete) Delete(path string) error {
 			return err
 		}
 
-		for i := 0; i < len(list); i++ {
-			cmd.Delete(path + "/" + list[i])
+		// オブジェクトを削除できなかった場合、コンテナは削除できない
+		if len(list) > 0 {
+			err = cmd.deleteObjects(strings.Trim(path, "/"), list)
+			if err != nil {
+				return err
+			}
 		}
 	}
 
@@ -106,6 +121,21 @@ func (cmd *Delete) Delete(path string) error {
 	return nil
 }
 
+// プレフィックスで始まるオブジェクトをすべて削除する
+func (cmd *Delete) deletePrefix(container string, prefix string) error {
+	l := NewCommand("list", cmd.config, cmd.stdStream, cmd.errStream).(*List)
+	list, err := l.ListPrefix(container, prefix)
+	if err != nil {
+		return err
+	}
+
+	if len(list) == 0 {
+		return errNotFound
+	}
+
+	return cmd.deleteObjects(container, list)
+}
+
 func (cmd *Delete) request(u *url.URL) error {
 
 	req, err := http.NewRequest(
@@ -124,10 +154,11 @@ func (cmd *Delete) request(u *url.URL) error {
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
 
 	switch {
 	case resp.StatusCode == 404:
-		return errors.New("Object was not found.")
+		return errNotFound
 
 	// オブジェクトを含むコンテナを削除すると409 Conflictになる
 	case resp.StatusCode == 409:
@@ -0,0 +1,60 @@
+package command
+
+// ディレクトリを表すオブジェクトの扱い
+//
+// uploadはディレクトリごとにContent-Typeがapplication/directoryの空のオブジェクトを作成する。
+// ダウンロードする場合は、これらのオブジェクトや名前が"/"で終わるオブジェクトを
+// ファイルではなくローカルのディレクトリとして作成する。
+
+import (
+	"errors"
+	"github.com/hironobu-s/conoha-ojs/lib"
+	"os"
+	"strings"
+)
+
+// ディレクトリを表すオブジェクトのContent-Type
+const DIRECTORY_CONTENT_TYPE = "application/directory"
+
+// ディレクトリを表すオブジェクトか調べる
+func isDirectoryMarker(name string, contentType string) bool {
+	if strings.HasSuffix(name, "/") {
+		return true
+	}
+
+	mediatype := strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])
+	return strings.EqualFold(mediatype, DIRECTORY_CONTENT_TYPE) || strings.EqualFold(mediatype, "application/x-directory")
+}
+
+// ディレクトリを表すオブジェクトを、ローカルのディレクトリとして作成する
+func (cmd *Download) createDirectory(srcpath string, destpath string) error {
+	log := lib.GetLogInstance()
+
+	if destpath == STDOUT_FILENAME {
+		return errors.New("A directory can not be written to standard output.")
+	}
+
+	u, err := buildStorageUrl(cmd.config.EndPointUrl, srcpath)
+	if err != nil {
+		return err
+	}
+
+	localpath, err := cmd.localPath(u, destpath)
+	if err != nil {
+		return err
+	}
+
+	if fi, err := os.Stat(localpath); err == nil && fi.IsDir() {
+		return nil
+	}
+
+	// 0777 で作成しているがumaskが考慮されるため実際は0755などになる
+	err = os.MkdirAll(localpath, 0777)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("%s directory was created.", localpath)
+
+	return nil
+}
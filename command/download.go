@@ -2,6 +2,8 @@ package command
 
 import (
 	"bufio"
+	"compress/gzip"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/hironobu-s/conoha-ojs/lib"
@@ -10,14 +12,66 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 )
 
+// ダウンロード先にこの名前を指定すると、標準出力に書き込む
+const STDOUT_FILENAME = "-"
+
+// ダウンロード中のデータを書き込むファイルの拡張子
+const PART_SUFFIX = ".part"
+
 type Download struct {
 	objectName string
 	destPath   string
 
+	// ETagによる検証を行わない
+	noVerify bool
+
+	// Content-Encoding: gzipのオブジェクトを展開して保存する
+	decompress bool
+
+	// 暗号化されたオブジェクトを復号する鍵
+	keyFile   string
+	noDecrypt bool
+	keys      *cryptoKeys
+
+	// 進捗表示
+	noProgress bool
+	progress   *progress
+
+	// ダウンロードする範囲
+	rangeSpec string
+	byteRange *byteRange
+
+	// 大きなオブジェクトを範囲に分けて並列にダウンロードする
+	parts    int
+	partSize bytesize
+
+	// ローカルのファイルシステムで使えないオブジェクト名を置き換える
+	sanitize bool
+
+	// ローカルのファイルと同じオブジェクトはダウンロードしない
+	changed    bool
+	downloaded int
+	skipped    int
+
+	// 置き換えるファイルを残す
+	backup       bool
+	backupSuffix string
+
+	// プレフィックスをダウンロードする場合に、オブジェクト名から取り除く"コンテナ/プレフィックス"
+	basePrefix string
+
+	// ディレクトリを作らずに、すべてのオブジェクトを保存先の直下に保存する
+	flatten   bool
+	flattened map[string]string
+
+	// アーカイブ(tar, tar.gz, zip)としてダウンロードする
+	archive string
+
 	*Command
 }
 
@@ -27,6 +81,22 @@ func (cmd *Download) parseFlags() (exitCode int, err error) {
 
 	fs := flag.NewFlagSet("conoha-ojs-download", flag.ContinueOnError)
 	fs.BoolVarP(&showUsage, "help", "h", false, "Print usage.")
+	fs.BoolVarP(&cmd.noVerify, "no-verify", "", false, "Do not verify ETag.")
+	fs.BoolVarP(&cmd.decompress, "decompress", "d", false, "Decompress gzip-encoded objects.")
+	fs.StringVarP(&cmd.keyFile, "key-file", "", "", "Key file for decryption.")
+	fs.BoolVarP(&cmd.noDecrypt, "no-decrypt", "", false, "Do not decrypt encrypted objects.")
+	fs.BoolVarP(&cmd.noProgress, "no-progress", "", false, "Do not show progress.")
+	fs.StringVarP(&cmd.rangeSpec, "range", "r", "", "Download a byte range of the object.")
+	fs.IntVarP(&cmd.parts, "parts", "", 1, "Number of parallel connections for a large object.")
+
+	cmd.partSize = DEFAULT_PART_SIZE
+	fs.VarP(&cmd.partSize, "part-size", "", "Size of each part for --parts.")
+	fs.BoolVarP(&cmd.sanitize, "sanitize", "", false, "Rewrite object names which are invalid as local file names.")
+	fs.BoolVarP(&cmd.changed, "changed", "", false, "Download only objects which differ from local files.")
+	fs.BoolVarP(&cmd.flatten, "flatten", "", false, "Save all objects directly under the destination path.")
+	fs.StringVarP(&cmd.archive, "archive", "", "", "Download objects as a tar, tar.gz or zip archive.")
+	fs.BoolVarP(&cmd.backup, "backup", "", false, "Keep a backup of replaced files.")
+	fs.StringVarP(&cmd.backupSuffix, "backup-suffix", "", DEFAULT_BACKUP_SUFFIX, "Suffix of backup files.")
 
 	err = fs.Parse(os.Args[2:])
 	if err != nil {
@@ -37,21 +107,62 @@ func (cmd *Download) parseFlags() (exitCode int, err error) {
 		return ExitCodeUsage, nil
 	}
 
-	if len(os.Args) < 3 {
+	if fs.NArg() < 1 {
 		return ExitCodeParseFlagError, errors.New("Not enough arguments.")
 	}
 
 	// 取得するオブジェクト名
-	cmd.objectName = os.Args[2]
+	cmd.objectName = fs.Arg(0)
 
 	// 保存先のパス
-	if len(os.Args) == 4 {
-		cmd.destPath = os.Args[3]
+	if fs.NArg() == 2 {
+		cmd.destPath = fs.Arg(1)
 
 	} else {
 		cmd.destPath = "."
 	}
 
+	if cmd.rangeSpec != "" {
+		cmd.byteRange, err = parseByteRange(cmd.rangeSpec)
+		if err != nil {
+			return ExitCodeParseFlagError, err
+		}
+	}
+
+	if cmd.archive != "" {
+		if err = validArchiveFormat(cmd.archive); err != nil {
+			return ExitCodeParseFlagError, err
+		}
+
+		if fs.NArg() < 2 {
+			return ExitCodeParseFlagError, errors.New("Specify a file name or \"-\" as <dest_path> for --archive.")
+		}
+
+		if cmd.byteRange != nil || cmd.changed {
+			return ExitCodeParseFlagError, errors.New("--range and --changed can not be used with --archive.")
+		}
+	}
+
+	if cmd.changed && cmd.destPath == STDOUT_FILENAME {
+		return ExitCodeParseFlagError, errors.New("--changed can not be used with standard output.")
+	}
+
+	if cmd.changed && cmd.byteRange != nil {
+		return ExitCodeParseFlagError, errors.New("--changed can not be used with --range.")
+	}
+
+	if cmd.backup && cmd.backupSuffix == "" {
+		return ExitCodeParseFlagError, errors.New("--backup-suffix should not be empty.")
+	}
+
+	if cmd.parts < 1 {
+		return ExitCodeParseFlagError, errors.New("--parts should be one or more.")
+	}
+
+	if cmd.partSize <= 0 {
+		return ExitCodeParseFlagError, errors.New("--part-size should be larger than zero.")
+	}
+
 	return ExitCodeOK, nil
 }
 
@@ -61,9 +172,78 @@ func (cmd *Download) Usage() {
 Download objects from a container.
 
 <object_name> Name of object to download.
+              If a container is given, all objects are saved in
+              "<dest_path>/<container>". If no object has the name, it is treated
+              as a pseudo-directory and all objects beginning with "<name>/" are
+              saved in <dest_path> with names relative to it.
 <dest_path>   (optional) Name of destination path. Default is current directory.
+              If "-" is given, write the object to standard output.
+              The data is verified at the end, and the exit code is non-zero
+              if the verification fails. Containers can not be written.
+
+Data is written to "<file>.part", synced to the disk and renamed over <file>
+after verification, so an existing file is kept intact if the download fails.
+If a download is interrupted, it is resumed from the .part file next time.
+
+      --no-verify:  Do not verify downloaded data with the ETag(MD5).
+
+  -r, --range:      Download only a byte range of the object. Example: -r 0-1023, -r 1024-
+                    The range is not verified. Encrypted objects are decrypted.
 
-`, lib.COMMAND_NAME)
+      --parts:      Download objects larger than --part-size in parts with
+                    this number of parallel connections. Default is 1.
+                    Each part is retried on failure, and the whole file is verified.
+                    Objects to be decrypted or decompressed are not split.
+
+      --part-size:  Size of each part for --parts. K, M and G suffixes are allowed.
+                    Default is 64M.
+
+      --archive:    Download a container, a pseudo-directory or an object as
+                    one archive (tar, tar.gz or zip) written to <dest_path> or
+                    standard output ("-"). Objects are streamed into the archive
+                    without being saved on disk. The modification time and the
+                    mode in the metadata and directory markers are kept.
+                    Example: --archive tar.gz <container>/photos photos.tar.gz
+
+      --flatten:    Save all objects directly under <dest_path> without
+                    directories. Objects which would be saved as the same file
+                    fail to download.
+
+      --changed:    Download only objects which differ from existing local files.
+                    The MD5 of the local file is sent as If-None-Match, and the
+                    file is not rewritten if the server returns 304 Not Modified.
+                    Encrypted, compressed and large objects are compared with
+                    the modification time in the metadata "Mtime" instead.
+                    The number of skipped files is shown at the end.
+
+      --backup:     Keep the file replaced by a download as "<file>~".
+
+      --backup-suffix: Suffix of backup files for --backup. Default is "~".
+
+      --sanitize:   Rewrite object names which can not be saved safely instead of
+                    refusing them. ".." is replaced with "_", and NUL, control
+                    characters, characters invalid on Windows (<>:"\|?*),
+                    trailing dots and spaces and reserved names (CON, NUL, ...)
+                    are rewritten. Each rewritten name is reported.
+                    Without this option, object names which escape <dest_path>
+                    or contain NUL are refused. Names longer than 255 bytes are
+                    always shortened.
+
+  -d, --decompress: Decompress objects which have "Content-Encoding: gzip".
+                    Decompressed data is verified with the original MD5
+                    stored in the metadata when uploading with --gzip.
+
+      --key-file:   Key file for decryption. Objects uploaded with --encrypt are
+                    decrypted automatically with this key, KeyFile in ~/.conoha-ojs,
+                    or the passphrase in %s.
+
+      --no-decrypt: Save encrypted objects without decryption.
+
+      --no-progress: Do not show progress. A summary is shown at the end.
+                    Progress bars are shown when stderr is a terminal,
+                    otherwise progress is logged periodically.
+
+`, lib.COMMAND_NAME, PASSPHRASE_ENV)
 }
 
 func (cmd *Download) Run() (exitCode int, err error) {
@@ -74,7 +254,21 @@ func (cmd *Download) Run() (exitCode int, err error) {
 		return exitCode, err
 	}
 
+	cmd.keys = newCryptoKeys(cmd.config, cmd.keyFile)
+
+	cmd.progress = newProgress(cmd.errStream, "Downloading", !cmd.noProgress)
+
 	err = cmd.DownloadObjects(cmd.objectName, cmd.destPath)
+
+	cmd.progress.Close()
+
+	log := lib.GetLogInstance()
+	log.Infof("Downloaded %s", cmd.progress.Summary())
+
+	if cmd.changed {
+		log.Infof("%d files were downloaded, %d files were skipped.", cmd.downloaded, cmd.skipped)
+	}
+
 	if err == nil {
 		return ExitCodeOK, nil
 	} else {
@@ -85,48 +279,200 @@ func (cmd *Download) Run() (exitCode int, err error) {
 func (cmd *Download) DownloadObjects(srcpath string, destpath string) error {
 	log := lib.GetLogInstance()
 
+	if cmd.archive != "" {
+		return cmd.downloadArchive(srcpath, destpath)
+	}
+
 	// 対象の情報を取得
-	s := NewCommand("stat", cmd.config, cmd.stdStream, cmd.errStream).(*Stat)
-	item, err := s.Stat(srcpath)
+	// オブジェクトが存在しない場合は、プレフィックスとして扱う
+	item, container, prefix, err := cmd.statOrPrefix(srcpath, true)
 	if err != nil {
 		return err
 	}
 
 	_, isContainer := item.(*Container)
 
+	if !isContainer && prefix == "" {
+		return cmd.downloadObject(srcpath, destpath)
+	}
+
+	if destpath == STDOUT_FILENAME {
+		return errors.New("A container or a prefix can not be downloaded to standard output.")
+	}
+
+	if cmd.byteRange != nil {
+		return errors.New("--range can not be used with a container or a prefix.")
+	}
+
 	if isContainer {
-		// オブジェクトの一覧を取得
-		l := NewCommand("list", cmd.config, cmd.stdStream, cmd.errStream).(*List)
-		list, err := l.List(srcpath)
+		container = strings.Trim(srcpath, "/")
+	} else {
+		// プレフィックスからの相対パスで保存する
+		cmd.basePrefix = container + "/" + prefix
+	}
+
+	// オブジェクトの一覧を取得
+	l := NewCommand("list", cmd.config, cmd.stdStream, cmd.errStream).(*List)
+	entries, err := l.ListDetail(container, prefix)
+	if err != nil {
+		return err
+	}
+
+	if prefix != "" {
+		// ディレクトリを表すオブジェクトは、中身がなくてもディレクトリを作成する
+		if item == nil && len(entries) == 0 {
+			return errNotFound
+		}
+
+		// 0777 で作成しているがumaskが考慮されるため実際は0755などになる
+		err = os.MkdirAll(destpath, 0777)
 		if err != nil {
 			return err
 		}
+	}
 
-		for i := 0; i < len(list); i++ {
-			cmd.DownloadObjects(srcpath+"/"+list[i], destpath)
+	// ディレクトリを表すオブジェクトは進捗に含めない
+	var total int64
+	files := 0
+	for _, entry := range entries {
+		if !isDirectoryMarker(entry.Name, entry.ContentType) {
+			total += entry.Bytes
+			files++
+		}
+	}
+	cmd.progress.SetTotal(files, total)
+
+	// 失敗したオブジェクトがあっても続ける
+	for _, entry := range entries {
+		name := container + "/" + entry.Name
+
+		if isDirectoryMarker(entry.Name, entry.ContentType) {
+			// --flattenの場合と、プレフィックス自体を表すオブジェクトはディレクトリを作成しない
+			if cmd.flatten || entry.Name == prefix {
+				continue
+			}
+
+			err = cmd.createDirectory(name, destpath)
+			if err != nil {
+				cmd.progress.Done(err)
+			}
+		} else {
+			err = cmd.downloadObject(name, destpath)
 		}
 
-	} else {
+		if err != nil {
+			log.Warnf("%s: %v", name, err)
+		}
+	}
+
+	if n := cmd.progress.Failures(); n > 0 {
+		return errors.New(fmt.Sprintf("%d objects failed to download.", n))
+	}
+
+	return nil
+}
+
+// オブジェクトを一つダウンロードする
+func (cmd *Download) downloadObject(srcpath string, destpath string) error {
+	log := lib.GetLogInstance()
+
+	log.Debugf("Downloading %s => %s", srcpath, destpath)
+
+	u, err := buildStorageUrl(cmd.config.EndPointUrl, srcpath)
+	if err != nil {
+		cmd.progress.Done(err)
+		return err
+	}
+
+	err = cmd.request(u, destpath)
+	if err == errNotModified {
+		log.Infof("%s is not changed. skipped.", srcpath)
+		cmd.skipped++
+		return nil
+	}
+
+	cmd.progress.Done(err)
+	if err != nil {
+		log.Infof("%s download error.", srcpath)
+		return err
+	}
+	log.Infof("%s download complete.", srcpath)
+	cmd.downloaded++
 
-		log.Debugf("Downloading %s => %s", srcpath, destpath)
+	return nil
+}
 
-		u, err := buildStorageUrl(cmd.config.EndPointUrl, srcpath)
+func (cmd *Download) request(u *url.URL, destpath string) error {
+
+	// 範囲を指定してダウンロードする
+	if cmd.byteRange != nil {
+		return cmd.request_range(u, destpath)
+	}
+
+	// 標準出力に書き込む場合は再開できない
+	if destpath == STDOUT_FILENAME {
+		resp, err := cmd.get(u, nil)
 		if err != nil {
 			return err
 		}
+		defer resp.Body.Close()
 
-		err = cmd.request(u, destpath)
+		err = responseError(resp)
 		if err != nil {
-			log.Infof("%s download error.", srcpath)
 			return err
 		}
-		log.Infof("%s download complete.", srcpath)
+
+		cmd.progress.Start(path.Base(u.Path), resp.ContentLength)
+
+		body, v, _, err := cmd.decodeBody(u, resp)
+		if err != nil {
+			return err
+		}
+
+		_, err = cmd.output(body, v)
+		return err
 	}
 
-	return nil
+	// オブジェクト名と同じファイルをローカルに作成してBodyを書き込む
+	localpath, err := cmd.localPath(u, destpath)
+	if err != nil {
+		return err
+	}
+
+	// ローカルのファイルがあれば、変更されている場合だけダウンロードする
+	var cond http.Header
+	if cmd.changed {
+		cond, err = cmd.conditionalHeader(u, localpath)
+		if err == errNotModified {
+			return cmd.notModified(localpath)
+		} else if err != nil {
+			return err
+		}
+	}
+
+	// 大きなオブジェクトは範囲に分けて並列にダウンロードする
+	if cmd.parts > 1 {
+		head, err := cmd.head(u, cond)
+		if err != nil {
+			return err
+		}
+
+		if head.StatusCode == http.StatusNotModified {
+			return cmd.notModified(localpath)
+		}
+
+		if cmd.parallelizable(head) {
+			return cmd.request_parallel(u, localpath, head)
+		}
+	}
+
+	return cmd.request_file(u, localpath, cond)
 }
 
-func (cmd *Download) request(u *url.URL, destpath string) error {
+// オブジェクトを取得する
+// headerには追加するヘッダを指定する(不要な場合はnil)
+// ステータスコードは呼び出し側で確認する
+func (cmd *Download) get(u *url.URL, header http.Header) (*http.Response, error) {
 
 	req, err := http.NewRequest(
 		"GET",
@@ -134,18 +480,23 @@ func (cmd *Download) request(u *url.URL, destpath string) error {
 		nil,
 	)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	req.Header.Set("X-Auth-Token", cmd.config.Token)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
+	// Content-Encodingが設定されたオブジェクトも、ETagと比較するために保存されたまま受け取る
+	req.Header.Set("Accept-Encoding", "identity")
+
+	for name, values := range header {
+		req.Header[name] = values
 	}
-	defer resp.Body.Close()
 
-	// HTTPステータスコードがエラーを返した場合
+	client := &http.Client{}
+	return client.Do(req)
+}
+
+// HTTPステータスコードがエラーを返した場合はエラーにする
+func responseError(resp *http.Response) error {
 	switch {
 	case resp.StatusCode == 404:
 		return errors.New("Object was not found.")
@@ -157,60 +508,363 @@ func (cmd *Download) request(u *url.URL, destpath string) error {
 		)
 		return errors.New(msg)
 	}
+	return nil
+}
 
-	// オブジェクト名と同じファイルをローカルに作成してBodyを書き込む
-	_, err = cmd.store(resp.Body, u, destpath)
+// 検証の準備をして、暗号化や圧縮されたオブジェクトを復号、展開しながら読み込むReaderを返す
+// 受信したデータをそのまま返す場合はrawがtrueになる
+func (cmd *Download) decodeBody(u *url.URL, resp *http.Response) (body io.Reader, v verifier, raw bool, err error) {
+
+	// 検証の準備
+	if !cmd.noVerify {
+		v, err = cmd.newVerifier(u, resp.Header)
+		if err != nil {
+			return nil, nil, false, err
+		}
+	}
+
+	body = cmd.progress.Reader(resp.Body)
+	raw = true
+
+	// 暗号化されたオブジェクトを復号する
+	params, err := cryptoParamsFromHeader(resp.Header)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if params != nil && !cmd.noDecrypt {
+		body, v, err = cmd.decryptReader(body, params, v)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		raw = false
+	}
+
+	// 圧縮されたオブジェクトを展開する
+	if cmd.decompress && strings.ToLower(resp.Header.Get("Content-Encoding")) == "gzip" {
+		body, v, err = cmd.decompressReader(body, resp.Header, v)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		raw = false
+	}
+
+	return body, v, raw, nil
+}
+
+// オブジェクトをファイルに保存する
+// 受信したデータは<ファイル名>.partに書き込み、検証できたらファイル名を変更する
+// 中断された場合は、次回は.partの続きから取得する
+// (オブジェクトが変更されていないことをIf-MatchとダウンロードしたときのETagで確認する)
+// condには条件付きリクエストのヘッダを指定する(不要な場合はnil)
+func (cmd *Download) request_file(u *url.URL, localpath string, cond http.Header) (err error) {
+	log := lib.GetLogInstance()
+
+	partpath := localpath + PART_SUFFIX
+	statePath, err := lib.DownloadStatePath(partpath)
+	if err != nil {
+		return err
+	}
+
+	// 途中までダウンロードしたファイルがあれば、続きから取得する
+	state := &lib.DownloadState{}
+	offset := int64(0)
+	if err = state.Read(statePath); err == nil && state.Url == u.String() && state.Etag != "" {
+		if fi, err := os.Stat(partpath); err == nil {
+			offset = fi.Size()
+		}
+	}
+
+	header := http.Header{}
+	if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		header.Set("If-Match", state.Etag)
+	} else {
+		for name, values := range cond {
+			header[name] = values
+		}
+	}
+
+	resp, err := cmd.get(u, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// オブジェクトが変更された場合(412)などは、最初からダウンロードし直す
+	if offset > 0 && !isRangeResponse(resp, offset) {
+		log.Infof("Cannot resume the download of %s. Downloading from the beginning.", localpath)
+		resp.Body.Close()
+		os.Remove(partpath)
+		state.Remove(statePath)
+		return cmd.request_file(u, localpath, cond)
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		return cmd.notModified(localpath)
+	}
+
+	err = responseError(resp)
 	if err != nil {
 		return err
 	}
 
+	size := int64(-1)
+	if resp.ContentLength >= 0 {
+		size = offset + resp.ContentLength
+	}
+	cmd.progress.Start(path.Base(u.Path), size)
+	cmd.progress.Skip(offset)
+
+	body, v, raw, err := cmd.decodeBody(u, resp)
+	if err != nil {
+		return err
+	}
+
+	// 復号や展開をする場合は、受信したデータと保存したデータの位置が一致しないので再開できない
+	if offset > 0 && !raw {
+		log.Infof("Cannot resume the download of %s. Downloading from the beginning.", localpath)
+		resp.Body.Close()
+		os.Remove(partpath)
+		state.Remove(statePath)
+		return cmd.request_file(u, localpath, cond)
+	}
+
+	if offset > 0 {
+		log.Infof("Resuming the download of %s from %d bytes.", localpath, offset)
+
+	} else if etag := resp.Header.Get("Etag"); raw && etag != "" {
+		state = &lib.DownloadState{Url: u.String(), Etag: etag}
+		if err = state.Save(statePath); err != nil {
+			log.Debugf("Cannot save the download state of %s. [%v]", localpath, err)
+		}
+	}
+
+	_, err = cmd.store(body, partpath, offset, v, raw)
+	if err != nil {
+		// 検証に失敗して.partが削除された場合は、途中経過も削除する
+		if _, statErr := os.Stat(partpath); os.IsNotExist(statErr) {
+			state.Remove(statePath)
+		}
+		return err
+	}
+
+	err = cmd.replaceFile(partpath, localpath, resp.Header)
+	if err != nil {
+		return err
+	}
+	state.Remove(statePath)
+
 	return nil
 }
 
-// オブジェクトをファイルに保存する
-// 保存したサイズを返す
-func (cmd *Download) store(body io.ReadCloser, u *url.URL, destpath string) (written int64, err error) {
+// Rangeリクエストに、指定した位置からのデータが返されたか調べる
+func isRangeResponse(resp *http.Response, offset int64) bool {
+	if resp.StatusCode != http.StatusPartialContent {
+		return false
+	}
+	return strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset))
+}
+
+// レスポンスヘッダからオブジェクトの種類を判断して、検証に使うverifierを返す
+// 検証できない場合はnilを返す
+func (cmd *Download) newVerifier(u *url.URL, header http.Header) (v verifier, err error) {
+	log := lib.GetLogInstance()
+
+	etag := header.Get("Etag")
 
-	rawurl := u.String()
+	switch {
+	case header.Get("X-Object-Manifest") != "":
+		// Dynamic Large ObjectのETagはダウンロード時点のセグメント一覧から計算されるので検証しない
+		log.Debugf("%s is a Dynamic Large Object. Skip verification.", u.Path)
+		return nil, nil
+
+	case strings.ToLower(header.Get("X-Static-Large-Object")) == "true":
+		segments, err := cmd.request_manifest(u)
+		if err != nil {
+			return nil, err
+		}
+		return newSloVerifier(etag, segments), nil
+
+	case etag == "":
+		log.Debugf("%s has no ETag. Skip verification.", u.Path)
+		return nil, nil
 
-	// オブジェクトを保存するローカルファイル名を決める
-	if !strings.Contains(rawurl, cmd.config.EndPointUrl) {
-		return -1, errors.New("Object URL dose not contain the EndPoint URL.")
+	default:
+		return newEtagVerifier(etag), nil
 	}
+}
 
-	// オブジェクトのURLからEndPointUrlの部分を削除して、基準のパスとする
-	path := strings.Replace(rawurl, cmd.config.EndPointUrl, "", 1)
+// gzipを展開しながら読み込むReaderと、展開後のデータを検証するverifierを返す
+// 展開前のデータはvで検証する
+func (cmd *Download) decompressReader(body io.Reader, header http.Header, v verifier) (io.Reader, verifier, error) {
+	if v != nil {
+		body = io.TeeReader(body, v)
+	}
 
-	// 保存先が引数で指定されている場合、そのパスを使う
-	path = destpath + string(filepath.Separator) + path
+	zr, err := gzip.NewReader(body)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	// パスを正規化する
-	path = filepath.Clean(path)
+	if cmd.noVerify {
+		return zr, nil, nil
+	}
+
+	return zr, newDecodedVerifier(v, header.Get(META_ORIGINAL_MD5)), nil
+}
+
+// Static Large Objectのマニフェストを取得する
+func (cmd *Download) request_manifest(u *url.URL) (segments []sloManifestEntry, err error) {
+
+	mu := *u
+	mu.RawQuery = "multipart-manifest=get"
 
-	// パスとファイル名に分離
-	dir, _ := filepath.Split(path)
+	req, err := http.NewRequest("GET", mu.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Auth-Token", cmd.config.Token)
+
+	// マニフェストにもオブジェクトのContent-Encodingが付くので、展開せずに受け取る
+	req.Header.Set("Accept-Encoding", "identity")
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == 404:
+		return nil, errors.New("Object was not found.")
+
+	case resp.StatusCode >= 400:
+		msg := fmt.Sprintf("Return %d status code from the server with message. [%s].",
+			resp.StatusCode,
+			extractErrorMessage(resp.Body),
+		)
+		return nil, errors.New(msg)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&segments)
+	if err != nil {
+		return nil, err
+	}
+
+	return segments, nil
+}
+
+// 受信したデータをファイルに書き込む
+// offsetが0より大きい場合は、ファイルのoffsetまでのデータをverifierに渡してから続きを追記する
+// verifierが指定された場合は保存したデータを検証し、一致しない場合はファイルを削除する
+// 受信に失敗した場合、keepPartialがtrueなら再開できるように途中まで書き込んだファイルを残す
+// 保存したサイズを返す
+func (cmd *Download) store(body io.Reader, filename string, offset int64, v verifier, keepPartial bool) (written int64, err error) {
 
 	// ディレクトリが存在しない場合は作成する
+	dir := filepath.Dir(filename)
 	_, err = os.Stat(dir)
 	if err != nil {
 		// 0777 で作成しているがumaskが考慮されるため実際は0755などになる
 		err = os.MkdirAll(dir, 0777)
+		if err != nil {
+			return -1, err
+		}
+	}
+
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if offset > 0 {
+		flag = os.O_RDWR
 	}
 
-	file, err := os.Create(path)
+	file, err := os.OpenFile(filename, flag, 0666)
 	if err != nil {
 		return -1, err
 	}
 	defer file.Close()
 
+	// 保存済みのデータも検証する
+	if offset > 0 {
+		if v != nil {
+			_, err = io.Copy(v, io.NewSectionReader(file, 0, offset))
+			if err != nil {
+				return -1, err
+			}
+		}
+
+		_, err = file.Seek(offset, os.SEEK_SET)
+		if err != nil {
+			return -1, err
+		}
+	}
+
 	// オブジェクトを保存
-	reader := bufio.NewReader(body)
 	writer := bufio.NewWriter(file)
-	written, err = io.Copy(writer, reader)
+
+	var w io.Writer = writer
+	if v != nil {
+		w = io.MultiWriter(writer, v)
+	}
+
+	written, err = io.Copy(w, body)
+	if flushErr := writer.Flush(); err == nil {
+		err = flushErr
+	}
+
+	if err != nil {
+		// 復号や展開に失敗した場合は、途中まで書き込んだファイルは残さない
+		file.Close()
+		if !keepPartial {
+			os.Remove(filename)
+		}
+		return -1, err
+	}
+
+	if v != nil {
+		err = v.Verify()
+		if err != nil {
+			file.Close()
+			os.Remove(filename)
+			return -1, err
+		}
+	}
+
+	// ファイル名を変更する前に、データをディスクに書き込む
+	err = file.Sync()
 	if err != nil {
 		return -1, err
 	}
-	writer.Flush()
+
+	return written, nil
+}
+
+// Bodyを標準出力に書き込む
+// 検証は最後に行うため、失敗してもすでに書き込んだデータは取り消せない
+func (cmd *Download) output(body io.Reader, v verifier) (written int64, err error) {
+	writer := bufio.NewWriter(cmd.stdStream)
+
+	var w io.Writer = writer
+	if v != nil {
+		w = io.MultiWriter(writer, v)
+	}
+
+	written, err = io.Copy(w, body)
+	if err != nil {
+		return -1, err
+	}
+
+	err = writer.Flush()
+	if err != nil {
+		return -1, err
+	}
+
+	if v != nil {
+		err = v.Verify()
+		if err != nil {
+			return -1, err
+		}
+	}
 
 	return written, nil
 }
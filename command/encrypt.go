@@ -0,0 +1,249 @@
+package command
+
+// クライアント側での暗号化と復号
+//
+// 暗号化の方式はlib/crypto.goを参照。暗号化に使ったパラメータはメタデータに記録する。
+// 鍵は鍵ファイル(--key-fileまたは設定ファイルのKeyFile)か、
+// 環境変数CONOHA_OJS_PASSPHRASEのパスフレーズから作る。
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/hironobu-s/conoha-ojs/lib"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// パスフレーズを指定する環境変数
+const PASSPHRASE_ENV = "CONOHA_OJS_PASSPHRASE"
+
+// 暗号化のパラメータを記録するメタデータ
+const (
+	META_CRYPTO_ALGORITHM  = "X-Object-Meta-Crypto-Algorithm"
+	META_CRYPTO_KEY_ID     = "X-Object-Meta-Crypto-Key-Id"
+	META_CRYPTO_KDF        = "X-Object-Meta-Crypto-Kdf"
+	META_CRYPTO_SALT       = "X-Object-Meta-Crypto-Salt"
+	META_CRYPTO_CHUNK_SIZE = "X-Object-Meta-Crypto-Chunk-Size"
+)
+
+// 暗号化と復号に使う鍵
+type cryptoKeys struct {
+	keyFile    string
+	passphrase string
+
+	// 鍵ファイルの鍵
+	raw *lib.CryptoKey
+
+	// パスフレーズから作った鍵(KDFのパラメータごと)
+	derived map[string]*lib.CryptoKey
+
+	// 暗号化に使う鍵
+	encryption *lib.CryptoKey
+}
+
+// 鍵ファイルの指定がなければ設定ファイルのKeyFileを使う
+func newCryptoKeys(config *lib.Config, keyFile string) *cryptoKeys {
+	if keyFile == "" {
+		keyFile = config.KeyFile
+	}
+
+	return &cryptoKeys{
+		keyFile:    keyFile,
+		passphrase: os.Getenv(PASSPHRASE_ENV),
+		derived:    map[string]*lib.CryptoKey{},
+	}
+}
+
+// 暗号化に使う鍵を返す
+// 鍵ファイルが指定されていなければパスフレーズから作る
+func (k *cryptoKeys) EncryptionKey() (key *lib.CryptoKey, err error) {
+	if k.encryption != nil {
+		return k.encryption, nil
+	}
+
+	switch {
+	case k.keyFile != "":
+		key, err = k.rawKey()
+
+	case k.passphrase != "":
+		// PBKDF2は時間がかかるので、同じ実行中は同じソルトの鍵を使う
+		key, err = lib.NewPassphraseKey(k.passphrase)
+
+	default:
+		err = errors.New(fmt.Sprintf("Encryption requires --key-file, KeyFile in the config file or %s.", PASSPHRASE_ENV))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	k.encryption = key
+	return key, nil
+}
+
+// 復号に使う鍵を返す
+func (k *cryptoKeys) DecryptionKey(params *lib.CryptoParams) (*lib.CryptoKey, error) {
+	if params.Kdf == "raw" {
+		if k.keyFile == "" {
+			return nil, errors.New("The object is encrypted with a key file. Specify it with --key-file or KeyFile in the config file.")
+		}
+		return k.rawKey()
+	}
+
+	if key, ok := k.derived[params.Kdf]; ok {
+		return key, nil
+	}
+
+	if k.passphrase == "" {
+		return nil, errors.New(fmt.Sprintf("The object is encrypted with a passphrase. Set it to %s.", PASSPHRASE_ENV))
+	}
+
+	key, err := lib.DerivePassphraseKey(k.passphrase, params.Kdf)
+	if err != nil {
+		return nil, err
+	}
+
+	k.derived[params.Kdf] = key
+	return key, nil
+}
+
+func (k *cryptoKeys) rawKey() (*lib.CryptoKey, error) {
+	if k.raw != nil {
+		return k.raw, nil
+	}
+
+	key, err := lib.ReadKeyFile(k.keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	k.raw = key
+	return key, nil
+}
+
+// 暗号化のパラメータをメタデータにする
+func cryptoHeader(params *lib.CryptoParams) http.Header {
+	header := http.Header{}
+	header.Set(META_CRYPTO_ALGORITHM, params.Algorithm)
+	header.Set(META_CRYPTO_KEY_ID, params.KeyId)
+	header.Set(META_CRYPTO_KDF, params.Kdf)
+	header.Set(META_CRYPTO_SALT, hex.EncodeToString(params.Salt))
+	header.Set(META_CRYPTO_CHUNK_SIZE, strconv.FormatInt(params.ChunkSize, 10))
+	return header
+}
+
+// メタデータから暗号化のパラメータを取得する
+// 暗号化されていない場合はnilを返す
+func cryptoParamsFromHeader(header http.Header) (*lib.CryptoParams, error) {
+	if header.Get(META_CRYPTO_ALGORITHM) == "" {
+		return nil, nil
+	}
+
+	salt, err := hex.DecodeString(header.Get(META_CRYPTO_SALT))
+	if err != nil {
+		return nil, errors.New("Invalid encryption parameters in the metadata.")
+	}
+
+	chunkSize, err := strconv.ParseInt(header.Get(META_CRYPTO_CHUNK_SIZE), 10, 64)
+	if err != nil {
+		return nil, errors.New("Invalid encryption parameters in the metadata.")
+	}
+
+	return &lib.CryptoParams{
+		Algorithm: header.Get(META_CRYPTO_ALGORITHM),
+		KeyId:     header.Get(META_CRYPTO_KEY_ID),
+		Kdf:       header.Get(META_CRYPTO_KDF),
+		Salt:      salt,
+		ChunkSize: chunkSize,
+	}, nil
+}
+
+// Statで取得したメタデータから暗号化のパラメータを取得する
+func cryptoParamsFromMetaDatas(metadatas map[string]string) (*lib.CryptoParams, error) {
+	header := http.Header{}
+	for name, value := range metadatas {
+		header.Set(name, value)
+	}
+	return cryptoParamsFromHeader(header)
+}
+
+// ファイルを暗号化しながらアップロードする
+func (cmd *Upload) request_encrypt(filename string, object string, fi os.FileInfo) (err error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return cmd.request_encrypt_stream(file, object, fi.Size(), cmd.attributesHeader(fi))
+}
+
+// Readerの内容を暗号化しながらアップロードする
+// 平文のサイズがわかる場合は、暗号文のサイズを計算してContent-Lengthを付ける(わからない場合は負の値)
+// 暗号化したオブジェクトのContent-Typeは application/octet-stream にする
+// headerにはオブジェクトごとのメタデータやヘッダを指定する(不要な場合はnil)
+func (cmd *Upload) request_encrypt_stream(reader io.Reader, object string, size int64, header http.Header) (err error) {
+	key, err := cmd.keys.EncryptionKey()
+	if err != nil {
+		return err
+	}
+
+	params, err := lib.NewCryptoParams(key)
+	if err != nil {
+		return err
+	}
+
+	encrypted, err := lib.NewEncryptReader(reader, key, params)
+	if err != nil {
+		return err
+	}
+
+	header = mergeHeader(header, cryptoHeader(params))
+
+	// 分割しない場合は、通常のオブジェクトとしてアップロードする
+	length := int64(-1)
+	if size >= 0 {
+		length = params.CipherSize(size)
+	}
+
+	if length < 0 || cmd.segmentSize > 0 && length > int64(cmd.segmentSize) {
+		return cmd.request_stream(encrypted, object, DEFAULT_CONTENT_TYPE, header)
+	}
+
+	_, err = cmd.request_object(object, encrypted, length, DEFAULT_CONTENT_TYPE, header)
+	if err != nil {
+		return err
+	}
+
+	log := lib.GetLogInstance()
+	log.Infof("%s (encrypted) was uploaded.", object)
+	cmd.uploaded++
+
+	return nil
+}
+
+// 復号しながら読み込むReaderと、検証に使うverifierを返す
+// 暗号文はvで検証する。平文はAES-GCMで認証されるので別に検証しない
+func (cmd *Download) decryptReader(body io.Reader, params *lib.CryptoParams, v verifier) (io.Reader, verifier, error) {
+	key, err := cmd.keys.DecryptionKey(params)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if v != nil {
+		body = io.TeeReader(body, v)
+	}
+
+	decrypted, err := lib.NewDecryptReader(body, key, params, 0, -1)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if v == nil {
+		return decrypted, nil, nil
+	}
+	return decrypted, newDecodedVerifier(v, ""), nil
+}
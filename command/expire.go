@@ -0,0 +1,285 @@
+package command
+
+// オブジェクトの有効期限(X-Delete-At, X-Delete-After)を操作する
+//
+// http://docs.openstack.org/developer/swift/overview_expiring_objects.html
+
+import (
+	"errors"
+	"fmt"
+	"github.com/hironobu-s/conoha-ojs/lib"
+	flag "github.com/ogier/pflag"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Expire struct {
+	objectName string
+
+	// 有効期限の指定
+	after  string
+	at     string
+	remove bool
+
+	// 指定した期間内に削除されるオブジェクトを表示する
+	within string
+
+	recursive bool
+
+	*Command
+}
+
+func (cmd *Expire) parseFlags() (exitCode int, err error) {
+
+	var showUsage bool
+
+	fs := flag.NewFlagSet("conoha-ojs-expire", flag.ContinueOnError)
+
+	fs.BoolVarP(&showUsage, "help", "h", false, "Print usage.")
+	fs.StringVarP(&cmd.after, "after", "a", "", "Set the expiration relative to now.")
+	fs.StringVarP(&cmd.at, "at", "t", "", "Set the expiration at the time.")
+	fs.BoolVarP(&cmd.remove, "remove", "d", false, "Remove the expiration.")
+	fs.StringVarP(&cmd.within, "list", "l", "", "List objects that will expire within the duration.")
+	fs.BoolVarP(&cmd.recursive, "recursive", "r", false, "Apply to all objects under the path.")
+
+	err = fs.Parse(os.Args[2:])
+	if err != nil {
+		return ExitCodeParseFlagError, err
+	}
+
+	if showUsage {
+		return ExitCodeUsage, nil
+	}
+
+	cmd.objectName = fs.Arg(0)
+	if cmd.objectName == "" {
+		return ExitCodeParseFlagError, errors.New("Not enough arguments.")
+	}
+
+	// 操作は一つだけ指定できる
+	actions := 0
+	for _, specified := range []bool{cmd.after != "", cmd.at != "", cmd.remove, cmd.within != ""} {
+		if specified {
+			actions++
+		}
+	}
+	if actions > 1 {
+		return ExitCodeParseFlagError, errors.New("--after, --at, --remove and --list can not be used together.")
+	}
+
+	return ExitCodeOK, nil
+}
+
+func (cmd *Expire) Usage() {
+	fmt.Fprintf(cmd.errStream, `Usage: %s expire [OPTIONS] <container or object>
+
+Set, show or remove the expiration of objects.
+If no options are given, show the expiration of objects.
+
+<container or object>  Name of container, object or prefix of objects.
+                       If no object has the name, all objects beginning with
+                       "<name>/" (a pseudo-directory) are used.
+
+  -a, --after:     Set the expiration relative to now.
+                   Example: -a 3600 (seconds), -a 12h, -a 30d
+
+  -t, --at:        Set the expiration at the time.
+                   Example: -t "2015-12-31 23:59:59", -t 2015-12-31T23:59:59+09:00
+
+  -d, --remove:    Remove the expiration.
+
+  -l, --list:      List objects that will expire within the duration.
+                   Example: -l 7d
+
+  -r, --recursive: Apply to the object and all objects under the path.
+                   If a container is given, it is always recursive.
+
+`, lib.COMMAND_NAME)
+}
+
+func (cmd *Expire) Run() (exitCode int, err error) {
+
+	exitCode, err = cmd.parseFlags()
+	if err != nil || exitCode == ExitCodeUsage {
+		cmd.Usage()
+		return exitCode, err
+	}
+
+	var d time.Duration
+	var t time.Time
+
+	switch {
+	case cmd.after != "":
+		if d, err = parseDuration(cmd.after); err == nil {
+			err = cmd.Expire(cmd.objectName, time.Now().Add(d))
+		}
+
+	case cmd.at != "":
+		if t, err = parseTime(cmd.at); err == nil {
+			err = cmd.Expire(cmd.objectName, t)
+		}
+
+	case cmd.remove:
+		err = cmd.Expire(cmd.objectName, time.Time{})
+
+	case cmd.within != "":
+		if d, err = parseDuration(cmd.within); err == nil {
+			err = cmd.ListExpiring(cmd.objectName, time.Now().Add(d))
+		}
+
+	default:
+		err = cmd.Show(cmd.objectName)
+	}
+
+	if err != nil {
+		return ExitCodeError, err
+	}
+
+	return ExitCodeOK, nil
+}
+
+// オブジェクトに有効期限を設定する
+// 有効期限がゼロ値の場合は削除する
+func (cmd *Expire) Expire(path string, deleteAt time.Time) error {
+	log := lib.GetLogInstance()
+
+	if !deleteAt.IsZero() && deleteAt.Before(time.Now()) {
+		return errors.New(fmt.Sprintf("%s is in the past.", deleteAt.Format(time.RFC1123)))
+	}
+
+	objects, err := cmd.statObjects(path, cmd.recursive)
+	if err != nil {
+		return err
+	}
+
+	for _, object := range objects {
+		err = cmd.request(object, deleteAt)
+		if err != nil {
+			return err
+		}
+
+		if deleteAt.IsZero() {
+			log.Infof("The expiration of %s was removed.", object.Object)
+		} else {
+			log.Infof("%s will be deleted at %s.", object.Object, deleteAt.Format(time.RFC1123))
+		}
+	}
+
+	return nil
+}
+
+// オブジェクトの有効期限を表示する
+func (cmd *Expire) Show(path string) error {
+	objects, err := cmd.statObjects(path, cmd.recursive)
+	if err != nil {
+		return err
+	}
+
+	for _, object := range objects {
+		cmd.printExpiration(object)
+	}
+
+	return nil
+}
+
+// 指定された日時までに削除されるオブジェクトを、削除される順に表示する
+func (cmd *Expire) ListExpiring(path string, until time.Time) error {
+	objects, err := cmd.statObjects(path, true)
+	if err != nil {
+		return err
+	}
+
+	expiring := []*Object{}
+	for _, object := range objects {
+		if !object.DeleteAt.IsZero() && !object.DeleteAt.After(until) {
+			expiring = append(expiring, object)
+		}
+	}
+
+	sort.Sort(byDeleteAt(expiring))
+
+	for _, object := range expiring {
+		cmd.printExpiration(object)
+	}
+
+	return nil
+}
+
+func (cmd *Expire) printExpiration(object *Object) {
+	if object.DeleteAt.IsZero() {
+		fmt.Fprintf(cmd.stdStream, "%-29s  %s\n", "-", object.Object)
+	} else {
+		fmt.Fprintf(cmd.stdStream, "%-29s  %s\n", object.DeleteAt.Format(time.RFC1123), object.Object)
+	}
+}
+
+// 削除される日時でソートする
+type byDeleteAt []*Object
+
+func (s byDeleteAt) Len() int           { return len(s) }
+func (s byDeleteAt) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byDeleteAt) Less(i, j int) bool { return s[i].DeleteAt.Before(s[j].DeleteAt) }
+
+// POSTで変更できる(POSTすると置き換えられる)ヘッダ
+// X-Object-Meta-*のメタデータもPOSTで置き換えられる
+var postAllowedHeaders = map[string]bool{
+	"Content-Disposition": true,
+	"Content-Encoding":    true,
+	"X-Object-Manifest":   true,
+	"Cache-Control":       true,
+	"Content-Language":    true,
+	"Expires":             true,
+	"X-Robots-Tag":        true,
+}
+
+// オブジェクトにPOSTリクエストを送信して有効期限を変更する
+// POSTするとメタデータと上記のヘッダが置き換えられるので、既存の値も送信する
+func (cmd *Expire) request(object *Object, deleteAt time.Time) error {
+
+	u, err := buildStorageUrl(cmd.config.EndPointUrl, object.Object)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Auth-Token", cmd.config.Token)
+
+	for name, value := range object.MetaDatas {
+		if strings.HasPrefix(name, "X-Object-Meta-") || postAllowedHeaders[name] {
+			req.Header.Set(name, value)
+		}
+	}
+
+	if deleteAt.IsZero() {
+		req.Header.Set("X-Remove-Delete-At", "1")
+	} else {
+		req.Header.Set("X-Delete-At", strconv.FormatInt(deleteAt.Unix(), 10))
+	}
+
+	cli := &http.Client{}
+	resp, err := cli.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == 404:
+		return errors.New("Object was not found.")
+	case resp.StatusCode >= 400:
+		msg := fmt.Sprintf("Return %d status code from the server with message. [%s].",
+			resp.StatusCode,
+			extractErrorMessage(resp.Body),
+		)
+		return errors.New(msg)
+	}
+
+	return nil
+}
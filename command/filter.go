@@ -0,0 +1,106 @@
+package command
+
+// アップロードするファイルの絞り込み
+
+import (
+	"fmt"
+	"github.com/hironobu-s/conoha-ojs/lib"
+	"os"
+	"path/filepath"
+)
+
+// ディレクトリに置くと、パターンにマッチするファイルをアップロードしない
+const IGNORE_FILENAME = ".ojsignore"
+
+type uploadFilter struct {
+	includes *lib.Patterns
+	excludes *lib.Patterns
+	maxSize  int64
+	minSize  int64
+}
+
+// アップロードするディレクトリ(またはファイル)ごとにフィルタを作成する
+// ディレクトリに.ojsignoreがあれば、コマンドラインで指定されたパターンより先に読み込む
+func (cmd *Upload) newFilter(root string) (filter *uploadFilter, err error) {
+	log := lib.GetLogInstance()
+
+	filter = &uploadFilter{
+		includes: lib.NewPatterns(),
+		excludes: lib.NewPatterns(),
+		maxSize:  int64(cmd.maxSize),
+		minSize:  int64(cmd.minSize),
+	}
+
+	ignoreFile := filepath.Join(root, IGNORE_FILENAME)
+	if _, err = os.Stat(ignoreFile); err == nil {
+		err = filter.excludes.ReadFile(ignoreFile)
+		if err != nil {
+			return nil, err
+		}
+		log.Debugf("%s was loaded.", ignoreFile)
+	}
+
+	for _, pattern := range cmd.excludes {
+		if err = filter.excludes.Add(pattern); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, pattern := range cmd.includes {
+		if err = filter.includes.Add(pattern); err != nil {
+			return nil, err
+		}
+	}
+
+	return filter, nil
+}
+
+// ファイルを除外する場合はtrueと理由を返す
+// pathはルートからの相対パスで、区切り文字は/とする
+func (f *uploadFilter) excluded(path string, info os.FileInfo) (bool, string) {
+
+	// ルートは除外しない
+	if path == "" {
+		return false, ""
+	}
+
+	if f.excludes.Match(path, info.IsDir()) {
+		return true, "matches an exclude pattern"
+	}
+
+	// ディレクトリは中のファイルを調べるため、--includeや--max-sizeなどで除外しない
+	if info.IsDir() {
+		return false, ""
+	}
+
+	if f.includes.Len() > 0 && !f.includes.Match(path, false) {
+		return true, "does not match include patterns"
+	}
+
+	if f.maxSize > 0 && info.Size() > f.maxSize {
+		return true, fmt.Sprintf("larger than %d bytes", f.maxSize)
+	}
+
+	if info.Size() < f.minSize {
+		return true, fmt.Sprintf("smaller than %d bytes", f.minSize)
+	}
+
+	return false, ""
+}
+
+// パターンと比較するための、ルートからの相対パスを返す
+// ルートにファイルが指定された場合はファイル名を返す
+func filterPath(root string, path string, info os.FileInfo) string {
+	if filepath.Clean(root) == filepath.Clean(path) {
+		if info.IsDir() {
+			return ""
+		}
+		return filepath.Base(path)
+	}
+
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	return filepath.ToSlash(rel)
+}
@@ -0,0 +1,301 @@
+package command
+
+// アップロード済みのオブジェクトのContent-Typeを判定し直して書き換える
+//
+// POSTでのContent-Typeの変更はサーバーの設定によって動作が異なるため、
+// X-Copy-Fromで同じ名前にサーバー側でコピーして書き換える。
+// コピーではメタデータは引き継がれるが、有効期限は引き継がれないので付け直す。
+
+import (
+	"errors"
+	"fmt"
+	"github.com/hironobu-s/conoha-ojs/lib"
+	flag "github.com/ogier/pflag"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type FixContentTypes struct {
+	objectName string
+	recursive  bool
+
+	// Content-Typeが空かapplication/octet-streamのオブジェクトだけを対象にする
+	unknownOnly bool
+
+	// 実際には書き換えない
+	dryRun bool
+
+	detector *contentTypeDetector
+
+	// 書き換えたオブジェクト数と変更がなかったオブジェクト数
+	fixed     int
+	unchanged int
+
+	*Command
+}
+
+func (cmd *FixContentTypes) parseFlags() (exitCode int, err error) {
+
+	var showUsage bool
+
+	fs := flag.NewFlagSet("conoha-ojs-fix-content-types", flag.ContinueOnError)
+
+	fs.BoolVarP(&showUsage, "help", "h", false, "Print usage.")
+	fs.BoolVarP(&cmd.recursive, "recursive", "r", false, "Apply to all objects under the path.")
+	fs.BoolVarP(&cmd.unknownOnly, "unknown-only", "u", false, "Fix only objects whose Content-type is unknown.")
+	fs.BoolVarP(&cmd.dryRun, "dry-run", "n", false, "Show changes without rewriting objects.")
+
+	err = fs.Parse(os.Args[2:])
+	if err != nil {
+		return ExitCodeParseFlagError, err
+	}
+
+	if showUsage {
+		return ExitCodeUsage, nil
+	}
+
+	cmd.objectName = fs.Arg(0)
+	if cmd.objectName == "" {
+		return ExitCodeParseFlagError, errors.New("Not enough arguments.")
+	}
+
+	return ExitCodeOK, nil
+}
+
+func (cmd *FixContentTypes) Usage() {
+	fmt.Fprintf(cmd.errStream, `Usage: %s fix-content-types [OPTIONS] <container or object>
+
+Detect Content-types of objects again, and rewrite them with a server-side copy.
+Content-types are detected in the same way as the upload command.
+Encrypted objects are left as they are.
+
+<container or object>  Name of container, object or prefix of objects.
+                       If no object has the name, all objects beginning with
+                       "<name>/" (a pseudo-directory) are used.
+
+  -r, --recursive:    Apply to the object and all objects under the path.
+                      If a container is given, it is always recursive.
+
+  -u, --unknown-only: Fix only objects whose Content-type is empty
+                      or "application/octet-stream".
+
+  -n, --dry-run:      Show changes without rewriting objects.
+
+`, lib.COMMAND_NAME)
+}
+
+func (cmd *FixContentTypes) Run() (exitCode int, err error) {
+
+	exitCode, err = cmd.parseFlags()
+	if err != nil || exitCode == ExitCodeUsage {
+		cmd.Usage()
+		return exitCode, err
+	}
+
+	cmd.detector, err = newContentTypeDetector(cmd.config)
+	if err != nil {
+		return ExitCodeError, err
+	}
+
+	objects, err := cmd.statObjects(cmd.objectName, cmd.recursive)
+	if err != nil {
+		return ExitCodeError, err
+	}
+
+	for _, object := range objects {
+		err = cmd.Fix(object)
+		if err != nil {
+			return ExitCodeError, err
+		}
+	}
+
+	log := lib.GetLogInstance()
+	log.Infof("%d objects were fixed, %d objects were unchanged.", cmd.fixed, cmd.unchanged)
+
+	return ExitCodeOK, nil
+}
+
+// オブジェクトのContent-Typeを判定し直して、異なっていれば書き換える
+func (cmd *FixContentTypes) Fix(object *Object) error {
+	log := lib.GetLogInstance()
+
+	current := object.ContentType
+
+	// ディレクトリはそのままにする
+	if strings.HasPrefix(current, "application/directory") {
+		cmd.unchanged++
+		return nil
+	}
+
+	// 暗号化されたオブジェクトは、アップロード時にapplication/octet-streamにしているのでそのままにする
+	if _, ok := object.MetaDatas[META_CRYPTO_ALGORITHM]; ok {
+		log.Debugf("%s: %s (encrypted)", object.Object, current)
+		cmd.unchanged++
+		return nil
+	}
+
+	if cmd.unknownOnly && current != "" && current != DEFAULT_CONTENT_TYPE {
+		cmd.unchanged++
+		return nil
+	}
+
+	contentType, err := cmd.detect(object)
+	if err != nil {
+		return err
+	}
+
+	// 判定できない場合は、Content-Typeが空のときだけデフォルトにする
+	if contentType == "" {
+		if current != "" {
+			cmd.unchanged++
+			return nil
+		}
+		contentType = DEFAULT_CONTENT_TYPE
+	}
+
+	if contentType == current {
+		log.Debugf("%s: %s (unchanged)", object.Object, current)
+		cmd.unchanged++
+		return nil
+	}
+
+	if cmd.dryRun {
+		log.Infof("%s: %s => %s", object.Object, current, contentType)
+		cmd.fixed++
+		return nil
+	}
+
+	err = cmd.request(object, contentType)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Content-type of %s was changed from \"%s\" to \"%s\".", object.Object, current, contentType)
+	cmd.fixed++
+
+	return nil
+}
+
+// オブジェクトのContent-Typeを判定する
+// 拡張子から判定できない場合は、オブジェクトの先頭部分をダウンロードして判定する
+func (cmd *FixContentTypes) detect(object *Object) (contentType string, err error) {
+	if contentType = cmd.detector.ByName(object.Object); contentType != "" {
+		return contentType, nil
+	}
+
+	// 圧縮されている場合は中身から判定できない
+	if _, ok := object.MetaDatas["Content-Encoding"]; ok {
+		return "", nil
+	}
+
+	head, err := cmd.request_head(object)
+	if err != nil {
+		return "", err
+	}
+
+	return sniffContentType(head), nil
+}
+
+// オブジェクトの先頭部分をRangeリクエストで取得する
+func (cmd *FixContentTypes) request_head(object *Object) (head []byte, err error) {
+	if object.ContentLength == 0 {
+		return nil, nil
+	}
+
+	u, err := buildStorageUrl(cmd.config.EndPointUrl, object.Object)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Auth-Token", cmd.config.Token)
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", SNIFF_LENGTH-1))
+	req.Header.Set("Accept-Encoding", "identity")
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == 404:
+		return nil, errors.New("Object was not found.")
+	case resp.StatusCode >= 400:
+		msg := fmt.Sprintf("Return %d status code from the server with message. [%s].",
+			resp.StatusCode,
+			extractErrorMessage(resp.Body),
+		)
+		return nil, errors.New(msg)
+	}
+
+	head = make([]byte, SNIFF_LENGTH)
+	n, err := io.ReadFull(resp.Body, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+
+	return head[:n], nil
+}
+
+// オブジェクトを同じ名前にサーバー側でコピーして、Content-Typeを書き換える
+// ラージオブジェクトの場合はセグメントを結合せず、マニフェストをコピーする
+func (cmd *FixContentTypes) request(object *Object, contentType string) error {
+
+	u, err := buildStorageUrl(cmd.config.EndPointUrl, object.Object)
+	if err != nil {
+		return err
+	}
+
+	_, isSlo := object.MetaDatas["X-Static-Large-Object"]
+	dloManifest, isDlo := object.MetaDatas["X-Object-Manifest"]
+	if isSlo || isDlo {
+		u.RawQuery = "multipart-manifest=get"
+	}
+
+	req, err := http.NewRequest("PUT", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Auth-Token", cmd.config.Token)
+	req.Header.Set("Content-Type", contentType)
+
+	src := &url.URL{Path: "/" + object.Object}
+	req.Header.Set("X-Copy-From", src.EscapedPath())
+
+	if isDlo {
+		req.Header.Set("X-Object-Manifest", dloManifest)
+	}
+
+	if !object.DeleteAt.IsZero() {
+		req.Header.Set("X-Delete-At", strconv.FormatInt(object.DeleteAt.Unix(), 10))
+	}
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == 404:
+		return errors.New("Object was not found.")
+	case resp.StatusCode >= 400:
+		msg := fmt.Sprintf("Return %d status code from the server with message. [%s].",
+			resp.StatusCode,
+			extractErrorMessage(resp.Body),
+		)
+		return errors.New(msg)
+	}
+
+	return nil
+}
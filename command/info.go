@@ -0,0 +1,107 @@
+package command
+
+// クラスタの機能(/info)の取得
+//
+// シンボリックリンクや一括アップロードなど、クラスタによって使えない機能があるため
+// 使う前に/infoで有効か調べる。
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hironobu-s/conoha-ojs/lib"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// 機能の名前と設定
+type capabilities map[string]json.RawMessage
+
+// 機能が有効か調べる
+func (c capabilities) Has(name string) bool {
+	_, ok := c[name]
+	return ok
+}
+
+// 機能の設定を取得する
+func (c capabilities) Get(name string, v interface{}) error {
+	raw, ok := c[name]
+	if !ok {
+		return errors.New(fmt.Sprintf("%s is not supported by the cluster.", name))
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// /infoのURL
+// EndPointUrlの/v1より前の部分に/infoを付ける
+func buildInfoUrl(endpointUrl string) (*url.URL, error) {
+	u, err := url.Parse(endpointUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	if i := strings.Index(u.Path, "/v1"); i >= 0 {
+		u.Path = u.Path[:i]
+	} else {
+		u.Path = ""
+	}
+	u.Path += "/info"
+	u.RawQuery = ""
+
+	return u, nil
+}
+
+// クラスタの機能を取得する
+// /infoが無効なクラスタではエラーになる
+func (cmd *Command) getCapabilities() (caps capabilities, err error) {
+	log := lib.GetLogInstance()
+
+	u, err := buildInfoUrl(cmd.config.EndPointUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Auth-Token", cmd.config.Token)
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		msg := fmt.Sprintf("Return %d status code from the server with message. [%s].",
+			resp.StatusCode,
+			extractErrorMessage(resp.Body),
+		)
+		return nil, errors.New(msg)
+	}
+
+	caps = capabilities{}
+	err = json.NewDecoder(resp.Body).Decode(&caps)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debugf("Capabilities of the cluster were retrieved from %s.", u.String())
+
+	return caps, nil
+}
+
+// 機能が有効か調べる
+// 取得できない場合は無効とみなす
+func (cmd *Command) hasCapability(name string) bool {
+	caps, err := cmd.getCapabilities()
+	if err != nil {
+		log := lib.GetLogInstance()
+		log.Debugf("Cannot get capabilities of the cluster. [%v]", err)
+		return false
+	}
+	return caps.Has(name)
+}
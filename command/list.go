@@ -2,11 +2,13 @@ package command
 
 import (
 	"bufio"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/hironobu-s/conoha-ojs/lib"
 	flag "github.com/ogier/pflag"
 	"net/http"
+	neturl "net/url"
 	"os"
 )
 
@@ -81,6 +83,111 @@ func (cmd *List) Run() (exitCode int, err error) {
 
 //  コンテナやオブジェクトを取得のリストを返す
 func (cmd *List) List(container string) (objects []string, err error) {
+	return cmd.ListPrefix(container, "")
+}
+
+// コンテナ内の、prefixで始まるオブジェクトのリストを返す
+// 一度に取得できる件数には上限があるので、markerを指定して最後まで取得する
+func (cmd *List) ListPrefix(container string, prefix string) (objects []string, err error) {
+
+	marker := ""
+	for {
+		query := neturl.Values{}
+		if prefix != "" {
+			query.Set("prefix", prefix)
+		}
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+
+		list, err := cmd.request(container, query)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(list) == 0 {
+			break
+		}
+
+		objects = append(objects, list...)
+		marker = list[len(list)-1]
+	}
+
+	return objects, nil
+}
+
+// JSON形式のリストで取得できるオブジェクトの情報
+type ObjectEntry struct {
+	Name         string `json:"name"`
+	Bytes        int64  `json:"bytes"`
+	Hash         string `json:"hash"`
+	ContentType  string `json:"content_type"`
+	LastModified string `json:"last_modified"`
+}
+
+// コンテナ内の、prefixで始まるオブジェクトの情報のリストを返す
+func (cmd *List) ListDetail(container string, prefix string) (objects []ObjectEntry, err error) {
+
+	marker := ""
+	for {
+		query := neturl.Values{}
+		query.Set("format", "json")
+		if prefix != "" {
+			query.Set("prefix", prefix)
+		}
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+
+		resp, err := cmd.get(container, query)
+		if err != nil {
+			return nil, err
+		}
+
+		// 以降のオブジェクトがない場合は204が返ることがある
+		if resp.StatusCode == http.StatusNoContent {
+			resp.Body.Close()
+			break
+		}
+
+		list := []ObjectEntry{}
+		err = json.NewDecoder(resp.Body).Decode(&list)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(list) == 0 {
+			break
+		}
+
+		objects = append(objects, list...)
+		marker = list[len(list)-1].Name
+	}
+
+	return objects, nil
+}
+
+// コンテナやオブジェクトのリストを一度だけ取得する
+func (cmd *List) request(container string, query neturl.Values) (objects []string, err error) {
+
+	resp, err := cmd.get(container, query)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		objects = append(objects, scanner.Text())
+	}
+
+	return objects, nil
+}
+
+// リストを取得するリクエストを送信する
+// 呼び出し側でレスポンスのBodyを閉じる
+func (cmd *List) get(container string, query neturl.Values) (resp *http.Response, err error) {
 
 	// URLを検証する
 	// rawurl := c.EndPointUrl + "/" + neturl.QueryEscape(container)
@@ -89,6 +196,7 @@ func (cmd *List) List(container string) (objects []string, err error) {
 	if err != nil {
 		return nil, err
 	}
+	url.RawQuery = query.Encode()
 
 	// リクエストを作成
 	req, err := http.NewRequest(
@@ -102,15 +210,15 @@ func (cmd *List) List(container string) (objects []string, err error) {
 	req.Header.Set("X-Auth-Token", cmd.config.Token)
 
 	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err = client.Do(req)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
 	// HTTPステータスコードがエラーを返した場合
 	switch {
 	case resp.StatusCode == 404:
+		resp.Body.Close()
 		return nil, errors.New("Object or Container was not found.")
 
 	case resp.StatusCode >= 400:
@@ -118,13 +226,9 @@ func (cmd *List) List(container string) (objects []string, err error) {
 			resp.StatusCode,
 			extractErrorMessage(resp.Body),
 		)
+		resp.Body.Close()
 		return nil, errors.New(msg)
 	}
 
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		objects = append(objects, scanner.Text())
-	}
-
-	return objects, nil
+	return resp, nil
 }
@@ -0,0 +1,197 @@
+package command
+
+// ダウンロードしたオブジェクトを保存するローカルファイルのパス
+//
+// オブジェクト名は任意の文字列なので、そのまま保存先に連結すると
+// ".."や絶対パスで保存先の外に書き込めてしまう。
+// オブジェクト名を"/"で区切った要素ごとに検査して、保存先の外に出る名前は拒否する。
+//
+//   - 先頭や連続する"/"、"."の要素は取り除く
+//   - ".."の要素、NULを含む名前は拒否する(--sanitizeの場合は"_"に置き換える)
+//   - MAX_NAME_LENGTHバイトより長い要素は切り詰めてハッシュを付ける
+//   - --sanitizeの場合は、Windowsなどで使えない文字や名前も置き換える
+//
+// 書き換えた名前はログに出力する。
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/hironobu-s/conoha-ojs/lib"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// ファイル名の最大のバイト数
+const MAX_NAME_LENGTH = 255
+
+// Windowsで使えないファイル名(拡張子を除いた部分で比較する)
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// オブジェクトを保存するローカルファイルのパスを返す
+// オブジェクトのURLからEndPointUrlの部分を削除して、保存先のパスに連結する
+func (cmd *Download) localPath(u *url.URL, destpath string) (string, error) {
+	name, safe, err := cmd.objectPath(u)
+	if err != nil {
+		return "", err
+	}
+
+	localpath := filepath.Join(destpath, filepath.FromSlash(safe))
+
+	// 念のため、保存先の外に出ていないことを確認する
+	r, err := filepath.Rel(filepath.Clean(destpath), localpath)
+	if err != nil || r == ".." || strings.HasPrefix(r, ".."+string(filepath.Separator)) {
+		return "", errors.New(fmt.Sprintf("Object name \"%s\" escapes the destination directory.", name))
+	}
+
+	return localpath, nil
+}
+
+// オブジェクトのURLから、保存先からの相対パス("/"区切り)を返す
+// nameは"コンテナ/オブジェクト"
+func (cmd *Download) objectPath(u *url.URL) (name string, safe string, err error) {
+	log := lib.GetLogInstance()
+
+	endpoint, err := url.Parse(cmd.config.EndPointUrl)
+	if err != nil {
+		return "", "", err
+	}
+
+	// オブジェクトのURLからEndPointUrlの部分を削除して、"コンテナ/オブジェクト"とする
+	base := strings.TrimSuffix(endpoint.Path, "/") + "/"
+	if !strings.HasPrefix(u.Path, base) {
+		return "", "", errors.New("Object URL dose not contain the EndPoint URL.")
+	}
+	name = u.Path[len(base):]
+
+	// プレフィックスをダウンロードする場合は、プレフィックスからの相対パスにする
+	rel := strings.TrimPrefix(name, cmd.basePrefix)
+
+	// --flattenの場合はディレクトリを取り除く
+	if cmd.flatten {
+		rel = path.Base(strings.TrimRight(rel, "/"))
+	}
+
+	safe, err = safeObjectPath(rel, cmd.sanitize)
+	if err != nil {
+		return "", "", err
+	}
+
+	if safe != rel {
+		log.Infof("%s is saved as %s.", name, safe)
+	}
+
+	// --flattenで同じファイル名になるオブジェクトは上書きしない
+	if cmd.flatten {
+		if cmd.flattened == nil {
+			cmd.flattened = map[string]string{}
+		}
+		if other, ok := cmd.flattened[safe]; ok && other != name {
+			return "", "", errors.New(fmt.Sprintf("%s is already saved as %s from %s.", name, safe, other))
+		}
+		cmd.flattened[safe] = name
+	}
+
+	return name, safe, nil
+}
+
+// オブジェクト名を保存先からの相対パス("/"区切り)にする
+// 保存先の外に出る名前や、保存できない名前はエラーを返す
+// sanitizeがtrueの場合は、エラーにせずに置き換える
+func safeObjectPath(name string, sanitize bool) (string, error) {
+	if strings.ContainsRune(name, 0) && !sanitize {
+		return "", errors.New(fmt.Sprintf("Object name \"%s\" contains a NUL byte. Use --sanitize to rewrite it.", strings.Replace(name, "\x00", `\0`, -1)))
+	}
+
+	elements := []string{}
+	for _, elem := range strings.Split(name, "/") {
+		switch elem {
+		case "", ".":
+			continue
+
+		case "..":
+			if !sanitize {
+				return "", errors.New(fmt.Sprintf("Object name \"%s\" escapes the destination directory. Use --sanitize to rewrite it.", name))
+			}
+			elem = "_"
+		}
+
+		// Windowsでは"\"も区切り文字になる
+		if filepath.Separator != '/' && strings.ContainsRune(elem, filepath.Separator) && !sanitize {
+			return "", errors.New(fmt.Sprintf("Object name \"%s\" contains a path separator. Use --sanitize to rewrite it.", name))
+		}
+
+		if sanitize {
+			elem = sanitizeName(elem)
+		}
+
+		elements = append(elements, shortenName(elem))
+	}
+
+	if len(elements) == 0 {
+		return "", errors.New(fmt.Sprintf("Object name \"%s\" is empty as a file name.", name))
+	}
+
+	return strings.Join(elements, "/"), nil
+}
+
+// ファイル名に使えない文字を"_"に置き換える
+// 制御文字とWindowsで使えない文字、末尾のピリオドと空白、予約されている名前を対象にする
+func sanitizeName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+
+	if trimmed := strings.TrimRight(name, ". "); trimmed != name {
+		name = trimmed + "_"
+	}
+
+	stem := name
+	if i := strings.Index(stem, "."); i >= 0 {
+		stem = stem[:i]
+	}
+	if reservedNames[strings.ToUpper(stem)] {
+		name = "_" + name
+	}
+
+	return name
+}
+
+// MAX_NAME_LENGTHバイトより長い名前を切り詰める
+// 切り詰めた名前が重ならないように、元の名前のハッシュと拡張子を付ける
+func shortenName(name string) string {
+	if len(name) <= MAX_NAME_LENGTH {
+		return name
+	}
+
+	sum := sha1.Sum([]byte(name))
+	suffix := "~" + hex.EncodeToString(sum[:])[:8]
+
+	// 長すぎる拡張子は付けない
+	ext := filepath.Ext(name)
+	if len(ext) > 16 {
+		ext = ""
+	}
+	suffix += ext
+
+	// UTF-8の文字の途中で切らない
+	n := MAX_NAME_LENGTH - len(suffix)
+	for n > 0 && !utf8.RuneStart(name[n]) {
+		n--
+	}
+
+	return name[:n] + suffix
+}
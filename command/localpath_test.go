@@ -0,0 +1,67 @@
+package command
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSafeObjectPath(t *testing.T) {
+	tests := []struct {
+		name     string
+		sanitize bool
+		path     string
+		ok       bool
+	}{
+		{"c/a.txt", false, "c/a.txt", true},
+		{"c//dir/./a.txt", false, "c/dir/a.txt", true},
+		{"/c/a.txt", false, "c/a.txt", true},
+		{"c/../../etc/passwd", false, "", false},
+		{"c/../../etc/passwd", true, "c/_/_/etc/passwd", true},
+		{"c/a\x00b", false, "", false},
+		{"c/a\x00b", true, "c/a_b", true},
+		{"c/a:b?.txt", true, "c/a_b_.txt", true},
+		{"c/a:b?.txt", false, "c/a:b?.txt", true},
+		{"c/dir. ", true, "c/dir_", true},
+		{"c/con.txt", true, "c/_con.txt", true},
+		{"c/", false, "c", true},
+		{"/./", false, "", false},
+	}
+
+	for _, test := range tests {
+		path, err := safeObjectPath(test.name, test.sanitize)
+		if !test.ok {
+			if err == nil {
+				t.Errorf("%q should be refused, but \"%s\"", test.name, path)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q: %v", test.name, err)
+			continue
+		}
+
+		if path != test.path {
+			t.Errorf("%q should be \"%s\", but \"%s\"", test.name, test.path, path)
+		}
+	}
+}
+
+func TestShortenName(t *testing.T) {
+	long := strings.Repeat("あ", 100) + ".txt"
+
+	name := shortenName(long)
+	if len(name) > MAX_NAME_LENGTH {
+		t.Errorf("%d bytes should be shortened to %d bytes or less", len(name), MAX_NAME_LENGTH)
+	}
+	if !strings.HasSuffix(name, ".txt") || !strings.HasPrefix(name, "あ") {
+		t.Errorf("unexpected shortened name \"%s\"", name)
+	}
+	if shortenName(long[:len(long)-4]+".dat") == name {
+		t.Errorf("shortened names should be different")
+	}
+
+	if shortenName("a.txt") != "a.txt" {
+		t.Errorf("short names should not be changed")
+	}
+}
@@ -37,6 +37,9 @@ Commands:
   delete    Delete a container or objects within a container.
   post      Update meta datas for the container or objects;
             create containers if not present.
+  expire    Set, show or remove the expiration of objects.
+  fix-content-types
+            Detect Content-types of objects again and rewrite them.
   deauth    Remove an authentication file (~/.conoha-ojs) from a local machine.
   version   Print version.
 
@@ -0,0 +1,77 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// アップロードするファイルのパスからオブジェクト名を決める
+//
+// base は引数で指定されたパス、path はその配下で見つかったファイルやディレクトリのパス
+//
+// 末尾がスラッシュのディレクトリを指定した場合は、そのディレクトリからの相対パスにする。
+// (/srv/www/ を指定すると /srv/www/index.html は index.html になる)
+// 絶対パスや .. で始まるパスを指定した場合は、指定したパスの親ディレクトリからの相対パスにする。
+// (../data/img.jpg は img.jpg に、/srv/www/index.html は www/index.html になる)
+// .. だけのパスは親ディレクトリの名前がないので、絶対パスにしてから同じように扱う。
+// (/home/user/src で .. を指定すると /home/user/a.txt は user/a.txt になる)
+// それ以外は指定されたパスをそのまま使う。./ などは取り除く。
+//
+// その後、先頭から strip 個の要素を取り除いて prefix を付ける
+// 要素がすべて取り除かれた場合は空文字を返す
+func buildObjectName(base string, path string, prefix string, strip int) (name string, err error) {
+
+	cleanBase := filepath.Clean(base)
+	cleanPath := filepath.Clean(path)
+
+	rel := cleanPath
+	switch {
+	case strings.HasSuffix(base, "/") || strings.HasSuffix(base, string(filepath.Separator)):
+		rel, err = filepath.Rel(cleanBase, cleanPath)
+
+	case filepath.IsAbs(cleanBase) || cleanBase == ".." || strings.HasPrefix(cleanBase, ".."+string(filepath.Separator)):
+		if filepath.Base(cleanBase) == ".." {
+			if cleanBase, err = filepath.Abs(cleanBase); err != nil {
+				return "", err
+			}
+			if cleanPath, err = filepath.Abs(cleanPath); err != nil {
+				return "", err
+			}
+		}
+		rel, err = filepath.Rel(filepath.Dir(cleanBase), cleanPath)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	// パスの要素に分解して、. や空の要素を取り除く
+	elements := []string{}
+	for _, elem := range strings.Split(filepath.ToSlash(rel), "/") {
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			return "", errors.New(fmt.Sprintf("\"%s\" is outside of \"%s\".", path, base))
+		}
+		elements = append(elements, elem)
+	}
+
+	if strip >= len(elements) {
+		return "", nil
+	}
+	elements = elements[strip:]
+
+	return joinObjectName(prefix, strings.Join(elements, "/")), nil
+}
+
+// オブジェクト名の先頭にprefixを付ける
+func joinObjectName(prefix string, name string) string {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return name
+	}
+	return prefix + "/" + name
+}
@@ -0,0 +1,60 @@
+package command
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuildObjectName(t *testing.T) {
+	// .. だけのパスは、親ディレクトリの名前からになる
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent := filepath.Base(filepath.Dir(wd))
+	grandparent := filepath.Base(filepath.Dir(filepath.Dir(wd)))
+
+	tests := []struct {
+		base   string
+		path   string
+		prefix string
+		strip  int
+		name   string
+	}{
+		{"a.txt", "a.txt", "", 0, "a.txt"},
+		{"./dir", "dir/a.txt", "", 0, "dir/a.txt"},
+		{"dir/", "dir/sub/a.txt", "", 0, "sub/a.txt"},
+		{"dir/", "dir", "", 0, ""},
+		{"../data/img.jpg", "../data/img.jpg", "", 0, "img.jpg"},
+		{"/srv/www", "/srv/www/index.html", "", 0, "www/index.html"},
+		{"/srv/www/", "/srv/www/index.html", "", 0, "index.html"},
+		{"dir", "dir/sub/a.txt", "", 1, "sub/a.txt"},
+		{"dir", "dir/sub/a.txt", "", 3, ""},
+		{"dir", "dir/a.txt", "backup/", 0, "backup/dir/a.txt"},
+		{"dir", "dir/a.txt", "/backup", 1, "backup/a.txt"},
+		{"..", "..", "", 0, parent},
+		{"..", "../a.txt", "", 0, parent + "/a.txt"},
+		{"../..", "../../dir/a.txt", "", 0, grandparent + "/dir/a.txt"},
+		{"../", "../a.txt", "", 0, "a.txt"},
+	}
+
+	for _, test := range tests {
+		name, err := buildObjectName(test.base, test.path, test.prefix, test.strip)
+		if err != nil {
+			t.Errorf("%s: %v", test.path, err)
+			continue
+		}
+
+		if name != test.name {
+			t.Errorf("%s should be \"%s\", but \"%s\"", test.path, test.name, name)
+		}
+	}
+}
+
+func TestBuildObjectNameOutsideOfBase(t *testing.T) {
+	_, err := buildObjectName("dir/", "other/a.txt", "", 0)
+	if err == nil {
+		t.Errorf("path outside of the base should be an error")
+	}
+}
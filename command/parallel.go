@@ -0,0 +1,252 @@
+package command
+
+// 大きなオブジェクトの並列ダウンロード(--parts, --part-size)
+//
+// オブジェクトを--part-sizeごとの範囲に分けて、--partsの数だけ並列にRangeリクエストで取得する。
+// 各範囲はあらかじめサイズを確保した.partファイルの該当する位置に書き込み、失敗した範囲は再試行する。
+// すべての範囲を取得したら、ファイル全体をETag(Static Large Objectはセグメントごとのハッシュ)で検証する。
+
+import (
+	"errors"
+	"fmt"
+	"github.com/hironobu-s/conoha-ojs/lib"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	// 範囲のサイズのデフォルト
+	DEFAULT_PART_SIZE = 64 << 20
+
+	// 範囲ごとの再試行の回数
+	PART_RETRY = 3
+)
+
+// 並列にダウンロードするか調べる
+// 復号や展開をする場合は、受信したデータをそのまま書き込めないので並列にしない
+func (cmd *Download) parallelizable(head *http.Response) bool {
+	if cmd.parts <= 1 || head.ContentLength <= int64(cmd.partSize) {
+		return false
+	}
+
+	if params, _ := cryptoParamsFromHeader(head.Header); params != nil && !cmd.noDecrypt {
+		return false
+	}
+
+	if cmd.decompress && strings.ToLower(head.Header.Get("Content-Encoding")) == "gzip" {
+		return false
+	}
+
+	return true
+}
+
+// オブジェクトを範囲に分けて並列にダウンロードする
+func (cmd *Download) request_parallel(u *url.URL, localpath string, head *http.Response) (err error) {
+	log := lib.GetLogInstance()
+
+	size := head.ContentLength
+	etag := head.Header.Get("Etag")
+
+	partpath := localpath + PART_SUFFIX
+
+	// 単一のダウンロードの途中経過は使わない
+	if statePath, err := lib.DownloadStatePath(partpath); err == nil {
+		(&lib.DownloadState{}).Remove(statePath)
+	}
+
+	dir := filepath.Dir(partpath)
+	if _, err = os.Stat(dir); err != nil {
+		// 0777 で作成しているがumaskが考慮されるため実際は0755などになる
+		err = os.MkdirAll(dir, 0777)
+		if err != nil {
+			return err
+		}
+	}
+
+	file, err := os.OpenFile(partpath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// 書き込む領域を確保する
+	err = file.Truncate(size)
+	if err != nil {
+		return err
+	}
+
+	count := (size + int64(cmd.partSize) - 1) / int64(cmd.partSize)
+	log.Debugf("Downloading %s in %d parts with %d connections.", u.Path, count, cmd.parts)
+
+	cmd.progress.Start(path.Base(u.Path), size)
+
+	// 範囲の開始位置をワーカーに渡す
+	starts := make(chan int64)
+	go func() {
+		for i := int64(0); i < count; i++ {
+			starts <- i * int64(cmd.partSize)
+		}
+		close(starts)
+	}()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []error
+
+	for i := 0; i < cmd.parts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for start := range starts {
+				// 失敗した範囲があれば、残りの範囲は取得しない
+				mu.Lock()
+				failed := len(errs) > 0
+				mu.Unlock()
+				if failed {
+					continue
+				}
+
+				end := start + int64(cmd.partSize) - 1
+				if end >= size {
+					end = size - 1
+				}
+
+				err := cmd.request_part(u, file, start, end, etag)
+				if err != nil {
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		file.Close()
+		os.Remove(partpath)
+		return errs[0]
+	}
+
+	// ファイル名を変更する前に、データをディスクに書き込む
+	err = file.Sync()
+	if err != nil {
+		file.Close()
+		os.Remove(partpath)
+		return err
+	}
+
+	// ファイル全体を検証する
+	if !cmd.noVerify {
+		v, err := cmd.newVerifier(u, head.Header)
+		if err != nil {
+			return err
+		}
+
+		if v != nil {
+			_, err = io.Copy(v, io.NewSectionReader(file, 0, size))
+			if err == nil {
+				err = v.Verify()
+			}
+			if err != nil {
+				file.Close()
+				os.Remove(partpath)
+				return err
+			}
+		}
+	}
+
+	file.Close()
+
+	return cmd.replaceFile(partpath, localpath, head.Header)
+}
+
+// 範囲を取得してファイルの該当する位置に書き込む
+// 失敗した場合はPART_RETRY回まで再試行する
+func (cmd *Download) request_part(u *url.URL, file *os.File, start int64, end int64, etag string) (err error) {
+	log := lib.GetLogInstance()
+
+	for retry := 0; retry <= PART_RETRY; retry++ {
+		if retry > 0 {
+			log.Infof("Retrying bytes %d-%d of %s. (%d/%d) [%v]", start, end, u.Path, retry, PART_RETRY, err)
+		}
+
+		var written int64
+		written, err = cmd.request_part_once(u, file, start, end, etag)
+		if err == nil {
+			return nil
+		}
+
+		// 失敗した範囲の受信量は進捗から除く
+		cmd.progress.Add(-written)
+
+		// オブジェクトが変更された場合は再試行しない
+		if err == errObjectChanged {
+			return err
+		}
+	}
+
+	return err
+}
+
+var errObjectChanged = errors.New("The object was changed while downloading.")
+
+// 範囲を一度だけ取得する
+// 書き込んだバイト数を返す
+func (cmd *Download) request_part_once(u *url.URL, file *os.File, start int64, end int64, etag string) (written int64, err error) {
+	header := http.Header{}
+	header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	if etag != "" {
+		header.Set("If-Match", etag)
+	}
+
+	resp, err := cmd.get(u, header)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		return 0, errObjectChanged
+	}
+
+	err = responseError(resp)
+	if err != nil {
+		return 0, err
+	}
+
+	if !isRangeResponse(resp, start) {
+		return 0, errors.New("The server does not support range requests.")
+	}
+
+	writer := &offsetWriter{file: file, offset: start}
+	written, err = io.Copy(writer, cmd.progress.Reader(io.LimitReader(resp.Body, end-start+1)))
+	if err != nil {
+		return written, err
+	}
+
+	if written != end-start+1 {
+		return written, io.ErrUnexpectedEOF
+	}
+
+	return written, nil
+}
+
+// ファイルの指定した位置から書き込む
+type offsetWriter struct {
+	file   *os.File
+	offset int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.file.WriteAt(p, w.offset)
+	w.offset += int64(n)
+	return n, err
+}
@@ -87,6 +87,8 @@ func (cmd *Post) Usage() {
 
 Update meta datas for the container, or object.
 If the container is not found, it will be created automatically.
+If no object has the name, meta datas of all objects beginning with
+"<name>/" (a pseudo-directory) are updated.
 
 <container or object>  Name of container or object to post to.
 
@@ -123,13 +125,16 @@ func (cmd *Post) Run() (exitCode int, err error) {
 func (cmd *Post) Post(path string) error {
 
 	// stat して対象が存在するか調べる
-	s := NewCommand("stat", cmd.config, cmd.stdStream, cmd.errStream).(*Stat)
-	item, err := s.Stat(path)
-	if err == nil {
+	// オブジェクトが存在しない場合は、プレフィックスとして扱う
+	item, container, prefix, err := cmd.statOrPrefix(path, false)
+	if err == nil && prefix != "" {
+		return cmd.postPrefix(container, prefix)
+
+	} else if err == nil {
 		// 対象が存在している
 		err = cmd.request("POST", item)
 
-	} else {
+	} else if _, object := splitPath(path); object == "" {
 		// エラーの場合は存在しないと仮定してコンテナを作成する
 		item = &Container{
 			Container: path,
@@ -144,6 +149,41 @@ func (cmd *Post) Post(path string) error {
 	return nil
 }
 
+// プレフィックスで始まるオブジェクトすべてのメタデータを更新する
+func (cmd *Post) postPrefix(container string, prefix string) error {
+	log := lib.GetLogInstance()
+
+	l := NewCommand("list", cmd.config, cmd.stdStream, cmd.errStream).(*List)
+	list, err := l.ListPrefix(container, prefix)
+	if err != nil {
+		return err
+	}
+
+	if len(list) == 0 {
+		return errNotFound
+	}
+
+	// 失敗したオブジェクトがあっても続ける
+	failures := 0
+	for _, name := range list {
+		item := &Object{Object: container + "/" + name}
+
+		err = cmd.request("POST", item)
+		if err != nil {
+			log.Warnf("%s: %v", item.Object, err)
+			failures++
+			continue
+		}
+		log.Infof("%s was updated.", item.Object)
+	}
+
+	if failures > 0 {
+		return errors.New(fmt.Sprintf("%d objects failed to update.", failures))
+	}
+
+	return nil
+}
+
 // PostやPutに使うURLを構築
 func (cmd *Post) storageUrl(item Item) (u *url.URL, err error) {
 
@@ -0,0 +1,89 @@
+package command
+
+// 疑似ディレクトリ(プレフィックス)の扱い
+//
+// Swiftにはディレクトリがないため、"コンテナ/photos/2015"のようにオブジェクトが存在しないパスは
+// "photos/2015/"で始まるオブジェクトをまとめて指定したものとして扱う。
+// downloadでは、ディレクトリを表すオブジェクト(application/directory)の場合も同じく扱う。
+// delete, stat, post, expire, fix-content-typesでは、ディレクトリを表すオブジェクトもそのオブジェクト自体を対象にする。
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 対象の情報を取得する
+// オブジェクトが存在しない場合は、パスをプレフィックスとして扱い、コンテナ名と"/"で終わるプレフィックスを返す
+// markerAsPrefixがtrueの場合は、ディレクトリを表すオブジェクトもプレフィックスとして扱う(itemにその情報を返す)
+// プレフィックスとして扱わない場合、prefixは空文字列になる
+func (cmd *Command) statOrPrefix(path string, markerAsPrefix bool) (item Item, container string, prefix string, err error) {
+	s := NewCommand("stat", cmd.config, cmd.stdStream, cmd.errStream).(*Stat)
+	item, err = s.Stat(path)
+	if err != nil && err != errNotFound {
+		return nil, "", "", err
+	}
+
+	if object, ok := item.(*Object); ok && !(markerAsPrefix && isDirectoryMarker(object.Object, object.ContentType)) {
+		return item, "", "", nil
+	}
+
+	if _, ok := item.(*Container); ok {
+		return item, "", "", nil
+	}
+
+	// コンテナが存在しない
+	container, prefix = splitPath(path)
+	if strings.Trim(prefix, "/") == "" {
+		return nil, "", "", err
+	}
+
+	prefix = strings.TrimSuffix(prefix, "/") + "/"
+	return item, container, prefix, nil
+}
+
+// プレフィックスで始まるオブジェクトの集計
+type Prefix struct {
+	Container string
+	Prefix    string
+	Objects   uint64
+	Bytes     uint64
+}
+
+func (item *Prefix) String() string {
+
+	padding := 10
+	format := "%" + strconv.Itoa(padding) + "s: "
+
+	lines := []string{}
+
+	lines = append(lines, fmt.Sprintf(format+"%s", "Container", item.Container))
+	lines = append(lines, fmt.Sprintf(format+"%s", "Prefix", item.Prefix))
+	lines = append(lines, fmt.Sprintf(format+"%d", "Objects", item.Objects))
+	lines = append(lines, fmt.Sprintf(format+"%d", "Bytes", item.Bytes))
+	lines = append(lines, "")
+
+	return strings.Join(lines, "\n")
+}
+
+// プレフィックスで始まるオブジェクトを集計する
+// 一致するオブジェクトがない場合はエラーを返す
+func (cmd *Stat) statPrefix(container string, prefix string) (*Prefix, error) {
+	l := NewCommand("list", cmd.config, cmd.stdStream, cmd.errStream).(*List)
+	entries, err := l.ListDetail(container, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(entries) == 0 {
+		return nil, errNotFound
+	}
+
+	item := &Prefix{Container: container, Prefix: prefix}
+	for _, entry := range entries {
+		item.Objects++
+		item.Bytes += uint64(entry.Bytes)
+	}
+
+	return item, nil
+}
@@ -0,0 +1,449 @@
+package command
+
+// アップロード/ダウンロードの進捗表示
+//
+// 標準エラー出力が端末の場合は、ファイルごとと全体の進捗バーを2行で表示する。
+// 端末でない場合は、一定時間ごとに進捗をログに出力する。
+// 最後に転送したファイル数、バイト数、平均速度と失敗した数を表示する。
+
+import (
+	"fmt"
+	"github.com/hironobu-s/conoha-ojs/lib"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// 端末で進捗バーを再描画する間隔
+	PROGRESS_DRAW_INTERVAL = 200 * time.Millisecond
+
+	// 端末でない場合に進捗をログに出力する間隔
+	PROGRESS_LOG_INTERVAL = 10 * time.Second
+
+	progressBarWidth  = 20
+	progressNameWidth = 30
+)
+
+type progress struct {
+	out     io.Writer
+	tty     bool
+	enabled bool
+
+	// "Uploading" や "Downloading"
+	action string
+
+	mu sync.Mutex
+
+	start time.Time
+
+	// 全体のファイル数とバイト数(わからない場合は0)
+	totalFiles int
+	totalBytes int64
+
+	// 完了したファイル数と失敗したファイル数
+	files    int
+	failures int
+
+	// 転送したバイト数と、スキップしたファイルを含めた処理済みのバイト数
+	transferred int64
+	processed   int64
+
+	// 転送中のファイル
+	name        string
+	size        int64
+	done        int64
+	fileStart   time.Time
+	fileSkipped int64
+	active      bool
+
+	// 圧縮する前のデータで数えている間は、送信するデータを数えない
+	source bool
+
+	lastDraw time.Time
+	lastLog  time.Time
+
+	// 進捗バーを表示している間、ログの出力先を置き換える
+	logOut io.Writer
+}
+
+// enabledがfalseの場合は進捗を表示せず、集計だけを行う
+func newProgress(out io.Writer, action string, enabled bool) *progress {
+	p := &progress{
+		out:     out,
+		tty:     isTerminal(out),
+		enabled: enabled,
+		action:  action,
+		start:   time.Now(),
+		lastLog: time.Now(),
+	}
+
+	// ログの出力で進捗バーが崩れないようにする
+	if p.enabled && p.tty {
+		log := lib.GetLogInstance()
+		p.logOut = log.Out
+		log.Out = &progressLogWriter{p: p}
+	}
+
+	return p
+}
+
+// 出力先が端末か調べる
+func isTerminal(w io.Writer) bool {
+	file, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+
+	fi, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// 全体のファイル数とバイト数を設定する
+func (p *progress) SetTotal(files int, bytes int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.totalFiles = files
+	p.totalBytes = bytes
+}
+
+// ファイルの転送を開始する
+// サイズがわからない場合は負の値を指定する
+func (p *progress) Start(name string, size int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.name = name
+	p.size = size
+	p.done = 0
+	p.fileStart = time.Now()
+	p.fileSkipped = 0
+	p.active = true
+	p.source = false
+
+	p.draw(true)
+}
+
+// 転送したバイト数を加算する
+func (p *progress) Add(n int64) {
+	p.add(n, false)
+}
+
+func (p *progress) add(n int64, source bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.source && !source {
+		return
+	}
+
+	p.transferred += n
+	p.processed += n
+	p.done += n
+
+	p.draw(false)
+}
+
+// 転送しなかったバイト数を処理済みにする(再開したセグメントなど)
+func (p *progress) Skip(n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.processed += n
+	p.done += n
+	p.fileSkipped += n
+
+	p.draw(false)
+}
+
+// ファイルの転送が終わった
+// errがnilでなければ失敗として数える
+func (p *progress) Done(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err != nil {
+		p.failures++
+	} else {
+		p.files++
+	}
+
+	// 転送量が想定より少なかった場合も、全体の進捗を合わせる
+	if p.active && p.size > p.done {
+		p.processed += p.size - p.done
+	}
+
+	p.clear()
+	p.active = false
+	p.size = 0
+	p.done = 0
+	p.source = false
+}
+
+// 失敗したファイルの数
+func (p *progress) Failures() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.failures
+}
+
+// 転送を待たずに、ファイルを処理済みにする(変更されていないファイルなど)
+func (p *progress) SkipFile(size int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.processed += size
+}
+
+// 進捗表示を終了して、ログの出力先を元に戻す
+func (p *progress) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.clear()
+	p.active = false
+
+	if p.logOut != nil {
+		lib.GetLogInstance().Out = p.logOut
+		p.logOut = nil
+	}
+}
+
+// 転送したファイル数、バイト数、平均速度と失敗した数を返す
+func (p *progress) Summary() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	elapsed := time.Since(p.start)
+	return fmt.Sprintf("%d files, %s in %s (%s/s average), %d failed.",
+		p.files,
+		formatBytes(p.transferred),
+		formatElapsed(elapsed),
+		formatBytes(transferRate(p.transferred, elapsed)),
+		p.failures,
+	)
+}
+
+// 読み込んだバイト数を進捗に加算するReaderを返す
+func (p *progress) Reader(reader io.Reader) io.Reader {
+	return &progressReader{reader: reader, p: p}
+}
+
+// 圧縮する前のデータを読み込むReaderを返す
+// ファイルの転送が終わるまで、圧縮した後のデータを読み込むReaderでは数えない
+func (p *progress) SourceReader(reader io.Reader) io.Reader {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.source = true
+	return &progressReader{reader: reader, p: p, source: true}
+}
+
+type progressReader struct {
+	reader io.Reader
+	p      *progress
+	source bool
+}
+
+func (r *progressReader) Read(b []byte) (n int, err error) {
+	n, err = r.reader.Read(b)
+	if n > 0 {
+		r.p.add(int64(n), r.source)
+	}
+	return n, err
+}
+
+// ログを出力する前に進捗バーを消して、出力した後に描き直す
+type progressLogWriter struct {
+	p *progress
+}
+
+func (w *progressLogWriter) Write(b []byte) (int, error) {
+	w.p.mu.Lock()
+	defer w.p.mu.Unlock()
+
+	w.p.clear()
+	n, err := w.p.logOut.Write(b)
+	w.p.draw(true)
+
+	return n, err
+}
+
+// 1秒あたりの転送量
+func transferRate(bytes int64, elapsed time.Duration) int64 {
+	if elapsed < time.Second {
+		return bytes
+	}
+	return int64(float64(bytes) / elapsed.Seconds())
+}
+
+// 進捗を表示する
+// forceがfalseの場合は、前回の表示から一定時間経過した場合のみ表示する
+// 呼び出し側でロックしておく
+func (p *progress) draw(force bool) {
+	if !p.enabled || !p.active {
+		return
+	}
+
+	now := time.Now()
+
+	if !p.tty {
+		if now.Sub(p.lastLog) < PROGRESS_LOG_INTERVAL {
+			return
+		}
+		p.lastLog = now
+
+		log := lib.GetLogInstance()
+		log.Infof("%s %s: %s | total: %s", p.action, p.name, p.fileStatus(), p.totalStatus())
+		return
+	}
+
+	if !force && now.Sub(p.lastDraw) < PROGRESS_DRAW_INTERVAL {
+		return
+	}
+	p.lastDraw = now
+
+	name := p.name
+	if len(name) > progressNameWidth {
+		name = "..." + name[len(name)-progressNameWidth+3:]
+	}
+
+	fmt.Fprintf(p.out, "\r\033[K%-*s %s %s\n\033[K%-*s %s %s\033[1A\r",
+		progressNameWidth, name, progressBar(p.done, p.size), p.fileStatus(),
+		progressNameWidth, "Total", progressBar(p.processed, p.totalBytes), p.totalStatus(),
+	)
+}
+
+// 進捗バーを消す
+// 呼び出し側でロックしておく
+func (p *progress) clear() {
+	if !p.enabled || !p.tty || !p.active {
+		return
+	}
+	fmt.Fprint(p.out, "\r\033[K\n\033[K\033[1A\r")
+}
+
+// 転送中のファイルの状態 (バイト数、割合、速度、残り時間)
+func (p *progress) fileStatus() string {
+	rate := transferRate(p.done-p.fileSkipped, time.Since(p.fileStart))
+
+	if p.size < 0 {
+		return fmt.Sprintf("%s  %s/s", formatBytes(p.done), formatBytes(rate))
+	}
+
+	return fmt.Sprintf("%3d%%  %s / %s  %s/s  ETA %s",
+		percent(p.done, p.size),
+		formatBytes(p.done),
+		formatBytes(p.size),
+		formatBytes(rate),
+		eta(p.size-p.done, rate),
+	)
+}
+
+// 全体の状態 (ファイル数、バイト数、割合、速度、残り時間)
+func (p *progress) totalStatus() string {
+	elapsed := time.Since(p.start)
+	rate := transferRate(p.transferred, elapsed)
+
+	files := fmt.Sprintf("%d", p.files+p.failures)
+	if p.totalFiles > 0 {
+		files += fmt.Sprintf("/%d", p.totalFiles)
+	}
+
+	if p.totalBytes <= 0 {
+		return fmt.Sprintf("%s files  %s  %s/s  %s",
+			files,
+			formatBytes(p.transferred),
+			formatBytes(rate),
+			formatElapsed(elapsed),
+		)
+	}
+
+	return fmt.Sprintf("%3d%%  %s files  %s / %s  %s/s  ETA %s",
+		percent(p.processed, p.totalBytes),
+		files,
+		formatBytes(p.processed),
+		formatBytes(p.totalBytes),
+		formatBytes(rate),
+		eta(p.totalBytes-p.processed, rate),
+	)
+}
+
+// 進捗バー
+func progressBar(done int64, total int64) string {
+	if total <= 0 {
+		return "[" + strings.Repeat(" ", progressBarWidth) + "]"
+	}
+
+	filled := int(int64(progressBarWidth) * done / total)
+	if filled > progressBarWidth {
+		filled = progressBarWidth
+	}
+
+	bar := strings.Repeat("=", filled)
+	if filled < progressBarWidth {
+		bar += ">" + strings.Repeat(" ", progressBarWidth-filled-1)
+	}
+	return "[" + bar + "]"
+}
+
+func percent(done int64, total int64) int64 {
+	if total <= 0 {
+		return 0
+	}
+
+	p := done * 100 / total
+	if p > 100 {
+		p = 100
+	}
+	return p
+}
+
+// 残り時間
+func eta(rest int64, rate int64) string {
+	if rate <= 0 {
+		return "--:--"
+	}
+	if rest < 0 {
+		rest = 0
+	}
+	return formatElapsed(time.Duration(rest/rate) * time.Second)
+}
+
+// バイト数を単位付きで表す
+func formatBytes(n int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+
+	size := float64(n)
+	i := 0
+	for size >= 1024 && i < len(units)-1 {
+		size /= 1024
+		i++
+	}
+
+	if i == 0 {
+		return fmt.Sprintf("%d %s", n, units[i])
+	}
+	return fmt.Sprintf("%.1f %s", size, units[i])
+}
+
+// 経過時間を 01:02:03 や 02:03 の形式で表す
+func formatElapsed(d time.Duration) string {
+	sec := int64(d.Seconds())
+
+	h := sec / 3600
+	m := sec % 3600 / 60
+	s := sec % 60
+
+	if h > 0 {
+		return fmt.Sprintf("%02d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%02d:%02d", m, s)
+}
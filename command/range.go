@@ -0,0 +1,207 @@
+package command
+
+// 範囲を指定したダウンロード(--range)
+
+import (
+	"errors"
+	"fmt"
+	"github.com/hironobu-s/conoha-ojs/lib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// ダウンロードするバイトの範囲(startからendバイト目まで)
+// endが負の場合は最後まで
+type byteRange struct {
+	start int64
+	end   int64
+}
+
+// "START-END" または "START-" の形式の範囲を読み込む
+func parseByteRange(s string) (*byteRange, error) {
+	invalid := errors.New(fmt.Sprintf("Invalid range \"%s\". It must be START-END or START-.", s))
+
+	i := strings.Index(s, "-")
+	if i <= 0 {
+		return nil, invalid
+	}
+
+	start, err := strconv.ParseInt(s[:i], 10, 64)
+	if err != nil || start < 0 {
+		return nil, invalid
+	}
+
+	end := int64(-1)
+	if s[i+1:] != "" {
+		end, err = strconv.ParseInt(s[i+1:], 10, 64)
+		if err != nil || end < start {
+			return nil, invalid
+		}
+	}
+
+	return &byteRange{start: start, end: end}, nil
+}
+
+// Rangeヘッダの値
+func (r *byteRange) String() string {
+	if r.end < 0 {
+		return fmt.Sprintf("bytes=%d-", r.start)
+	}
+	return fmt.Sprintf("bytes=%d-%d", r.start, r.end)
+}
+
+// オブジェクトの一部をダウンロードする
+// オブジェクト全体のETagとは比較できないので検証しない
+// 暗号化されたオブジェクトは、範囲を含むチャンクを取得して復号する
+func (cmd *Download) request_range(u *url.URL, destpath string) (err error) {
+	log := lib.GetLogInstance()
+
+	head, err := cmd.head(u, nil)
+	if err != nil {
+		return err
+	}
+
+	params, err := cryptoParamsFromHeader(head.Header)
+	if err != nil {
+		return err
+	}
+
+	decrypt := params != nil && !cmd.noDecrypt
+
+	// 暗号化されたオブジェクトは、平文の範囲から暗号文の範囲を計算する
+	r := *cmd.byteRange
+	var firstChunk, skip int64
+	if decrypt {
+		plainSize, err := params.PlainSize(head.ContentLength)
+		if err != nil {
+			return err
+		}
+
+		if r.end < 0 || r.end >= plainSize {
+			r.end = plainSize - 1
+		}
+		if r.start > r.end {
+			return errors.New("The range is out of the object.")
+		}
+
+		var cstart, cend int64
+		cstart, cend, firstChunk, skip = params.CipherRange(r.start, r.end, head.ContentLength)
+		r = byteRange{start: cstart, end: cend}
+	}
+
+	header := http.Header{}
+	header.Set("Range", r.String())
+	if etag := head.Header.Get("Etag"); etag != "" {
+		header.Set("If-Match", etag)
+	}
+
+	resp, err := cmd.get(u, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	err = responseError(resp)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return errors.New("The server does not support range requests.")
+	}
+
+	cmd.progress.Start(path.Base(u.Path), resp.ContentLength)
+
+	var body io.Reader = cmd.progress.Reader(resp.Body)
+	if decrypt {
+		key, err := cmd.keys.DecryptionKey(params)
+		if err != nil {
+			return err
+		}
+
+		body, err = lib.NewDecryptReader(body, key, params, firstChunk, params.LastChunk(head.ContentLength))
+		if err != nil {
+			return err
+		}
+
+		// チャンクの先頭から範囲の先頭までを読み飛ばす
+		_, err = io.CopyN(ioutil.Discard, body, skip)
+		if err != nil {
+			return err
+		}
+
+		end := cmd.byteRange.end
+		plainSize, _ := params.PlainSize(head.ContentLength)
+		if end < 0 || end >= plainSize {
+			end = plainSize - 1
+		}
+		body = io.LimitReader(body, end-cmd.byteRange.start+1)
+	}
+
+	if cmd.decompress && strings.ToLower(resp.Header.Get("Content-Encoding")) == "gzip" {
+		log.Infof("A range of a compressed object can not be decompressed. It is saved as it is.")
+	}
+
+	if destpath == STDOUT_FILENAME {
+		_, err = cmd.output(body, nil)
+		return err
+	}
+
+	localpath, err := cmd.localPath(u, destpath)
+	if err != nil {
+		return err
+	}
+
+	// 一時ファイルに保存してから置き換える
+	// 再開用の.partと重ならないように別の名前にする
+	tmppath := localpath + ".range" + PART_SUFFIX
+
+	_, err = cmd.store(body, tmppath, 0, nil, false)
+	if err != nil {
+		return err
+	}
+
+	err = cmd.replaceFile(tmppath, localpath, nil)
+	if err != nil {
+		os.Remove(tmppath)
+		return err
+	}
+
+	return nil
+}
+
+// オブジェクトのヘッダを取得する
+// headerには追加するヘッダを指定する(不要な場合はnil)
+func (cmd *Download) head(u *url.URL, header http.Header) (*http.Response, error) {
+
+	req, err := http.NewRequest("HEAD", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Auth-Token", cmd.config.Token)
+	req.Header.Set("Accept-Encoding", "identity")
+
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	err = responseError(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,416 @@
+package command
+
+// ラージオブジェクト(Static Large Object)のアップロード
+// ファイルをセグメントに分割してアップロードし、最後にマニフェストを作成する
+//
+// http://docs.openstack.org/developer/swift/overview_large_objects.html
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hironobu-s/conoha-ojs/lib"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// マニフェストに記述するセグメントの情報
+type sloSegment struct {
+	Path      string `json:"path"`
+	Etag      string `json:"etag"`
+	SizeBytes int64  `json:"size_bytes"`
+}
+
+// セグメントを格納するコンテナ名
+// python-swiftclientと同じく、アップロード先のコンテナ名に_segmentsを付けたものにする
+func (cmd *Upload) segmentContainer() string {
+	return cmd.destContainer + "_segments"
+}
+
+// -Hで指定された有効期限(X-Delete-At, X-Delete-After)を、X-Delete-Atの値(UNIX時間)にして返す
+// 指定されていない場合は空文字を返す
+// X-Delete-Afterはリクエストごとに基準の時刻が変わるため、ここで時刻にしてセグメントとマニフェストで揃える
+// Swiftと同じく、両方指定された場合はX-Delete-Afterを優先する
+func (cmd *Upload) deleteAt() (string, error) {
+	var at, after string
+	for name, value := range cmd.headers {
+		switch http.CanonicalHeaderKey(name) {
+		case "X-Delete-At":
+			at = value
+		case "X-Delete-After":
+			after = value
+		}
+	}
+
+	if after != "" {
+		sec, err := strconv.ParseInt(after, 10, 64)
+		if err != nil || sec < 0 {
+			return "", errors.New(fmt.Sprintf("\"%s\" is invalid X-Delete-After.", after))
+		}
+		return strconv.FormatInt(time.Now().Unix()+sec, 10), nil
+	}
+
+	if at != "" {
+		if _, err := strconv.ParseInt(at, 10, 64); err != nil {
+			return "", errors.New(fmt.Sprintf("\"%s\" is invalid X-Delete-At.", at))
+		}
+	}
+	return at, nil
+}
+
+// オブジェクトごとのヘッダに、-Hで指定された有効期限をX-Delete-Atとして追加したヘッダを返す
+// すでにX-Delete-Atが追加されている場合はそのまま使う
+// 返したヘッダは呼び出し側で変更してもよい
+func (cmd *Upload) withDeleteAt(header http.Header) (http.Header, error) {
+	header = mergeHeader(header)
+	if header.Get("X-Delete-At") != "" {
+		return header, nil
+	}
+
+	deleteAt, err := cmd.deleteAt()
+	if err != nil {
+		return nil, err
+	}
+	if deleteAt != "" {
+		header.Set("X-Delete-At", deleteAt)
+	}
+	return header, nil
+}
+
+// ファイルをセグメントに分割してアップロードする
+// 途中経過はファイルに保存して、--resume が指定された場合はそこから再開する
+func (cmd *Upload) request_segmented(filename string, object string, fi os.FileInfo) (err error) {
+	log := lib.GetLogInstance()
+
+	// セグメントにもマニフェストと同じ有効期限を付けて、マニフェストと一緒に削除されるようにする
+	deleteAt, err := cmd.deleteAt()
+	if err != nil {
+		return err
+	}
+
+	statePath, err := lib.UploadStatePath(cmd.destContainer, object)
+	if err != nil {
+		return err
+	}
+
+	// 途中経過を読み込む
+	var state *lib.UploadState
+	if cmd.resume {
+		state = &lib.UploadState{}
+		err = state.Read(statePath)
+		if err != nil || !state.Matches(filename, fi, int64(cmd.segmentSize)) {
+			log.Debugf("No resumable upload was found for %s.", filename)
+			state = nil
+		}
+	}
+
+	if state == nil {
+		count := (fi.Size() + int64(cmd.segmentSize) - 1) / int64(cmd.segmentSize)
+
+		state = &lib.UploadState{
+			Path:             filename,
+			Size:             fi.Size(),
+			ModTime:          fi.ModTime().UnixNano(),
+			Container:        cmd.destContainer,
+			Object:           object,
+			SegmentContainer: cmd.segmentContainer(),
+			SegmentPrefix:    fmt.Sprintf("%s/slo/%d/%d/%d", object, fi.ModTime().Unix(), fi.Size(), cmd.segmentSize),
+			SegmentSize:      int64(cmd.segmentSize),
+			Segments:         make([]string, count),
+		}
+	} else {
+		log.Infof("Resuming upload of %s. (%d/%d segments)", filename, state.Completed(), len(state.Segments))
+	}
+
+	// セグメント用のコンテナを作成
+	err = cmd.request_container(state.SegmentContainer)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	segments := make([]sloSegment, len(state.Segments))
+	for i := 0; i < len(state.Segments); i++ {
+		offset := int64(i) * state.SegmentSize
+		length := state.SegmentSize
+		if offset+length > state.Size {
+			length = state.Size - offset
+		}
+
+		name := fmt.Sprintf("%s/%08d", state.SegmentPrefix, i)
+
+		// アップロード済みのセグメントはHEADで確認できればスキップする
+		if state.Segments[i] != "" {
+			if cmd.confirmSegment(state.SegmentContainer+"/"+name, state.Segments[i], length) {
+				log.Infof("%s segment %d/%d was already uploaded.", filename, i+1, len(state.Segments))
+				cmd.progress.Skip(length)
+
+				// 前回のアップロードでは有効期限が異なる場合があるため付け直す
+				if deleteAt != "" {
+					err = cmd.request_segment_expiry(state.SegmentContainer, name, deleteAt)
+					if err != nil {
+						return err
+					}
+				}
+			} else {
+				state.Segments[i] = ""
+			}
+		}
+
+		if state.Segments[i] == "" {
+			reader := io.NewSectionReader(file, offset, length)
+			etag, err := cmd.request_segment(state.SegmentContainer, name, reader, length, deleteAt)
+			if err != nil {
+				return err
+			}
+
+			state.Segments[i] = etag
+			err = state.Save(statePath)
+			if err != nil {
+				return err
+			}
+			log.Infof("%s segment %d/%d was uploaded.", filename, i+1, len(state.Segments))
+		}
+
+		segments[i] = sloSegment{
+			Path:      "/" + state.SegmentContainer + "/" + name,
+			Etag:      state.Segments[i],
+			SizeBytes: length,
+		}
+	}
+
+	// マニフェストを作成する
+	contentType := cmd.detectFileContentType(filename)
+	err = cmd.request_manifest(object, segments, contentType, cmd.attributesHeader(fi), deleteAt)
+	if err != nil {
+		return err
+	}
+
+	// アップロードが完了したので途中経過は不要
+	err = state.Remove(statePath)
+	if err != nil {
+		log.Warnf("Cannot remove the upload state file. [%s]", statePath)
+	}
+
+	log.Infof("%s (content-type: %s, %d segments) was uploaded.", filename, contentType, len(segments))
+
+	return nil
+}
+
+// アップロード済みのセグメントがサーバ上に存在して、ETagとサイズが一致するか確認する
+func (cmd *Upload) confirmSegment(path string, etag string, size int64) bool {
+	s := NewCommand("stat", cmd.config, cmd.stdStream, cmd.errStream).(*Stat)
+	item, err := s.Stat(path)
+	if err != nil {
+		return false
+	}
+
+	obj, ok := item.(*Object)
+	if !ok {
+		return false
+	}
+
+	return strings.Trim(obj.ETag, `"`) == etag && int64(obj.ContentLength) == size
+}
+
+// コンテナを作成する
+// すでに存在する場合は何もしない
+func (cmd *Upload) request_container(container string) (err error) {
+
+	uri, err := buildStorageUrl(cmd.config.EndPointUrl, container)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("PUT", uri.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Auth-Token", cmd.config.Token)
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		msg := fmt.Sprintf("Return %d status code from the server with message. [%s].",
+			resp.StatusCode,
+			extractErrorMessage(resp.Body),
+		)
+		return errors.New(msg)
+	}
+
+	return nil
+}
+
+// セグメントを一つアップロードして、サーバが返したETagを返す
+// deleteAtが空でない場合は、有効期限を付ける
+func (cmd *Upload) request_segment(container string, name string, reader io.Reader, length int64, deleteAt string) (etag string, err error) {
+
+	uri, err := buildStorageUrl(cmd.config.EndPointUrl, container, name)
+	if err != nil {
+		return "", err
+	}
+
+	// 送信しながらMD5を計算する
+	hash := md5.New()
+
+	req, err := http.NewRequest("PUT", uri.String(), io.TeeReader(cmd.progress.Reader(reader), hash))
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = length
+	req.Header.Set("X-Auth-Token", cmd.config.Token)
+	if deleteAt != "" {
+		req.Header.Set("X-Delete-At", deleteAt)
+	}
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == 404:
+		return "", errors.New("Container was not found.")
+
+	case resp.StatusCode >= 400:
+		msg := fmt.Sprintf("Return %d status code from the server with message. [%s].",
+			resp.StatusCode,
+			extractErrorMessage(resp.Body),
+		)
+		return "", errors.New(msg)
+	}
+
+	etag = resp.Header.Get("Etag")
+	if etag == "" {
+		return "", errors.New("Server did not return ETag of the segment.")
+	}
+
+	if !cmd.noVerify {
+		err = verifyEtag(hex.EncodeToString(hash.Sum(nil)), etag)
+		if err != nil {
+			return "", errors.New(fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+
+	return etag, nil
+}
+
+// セグメントをまとめるマニフェストを作成する
+// 有効期限はセグメントと揃えるため、-Hの指定ではなくdeleteAtを使う
+func (cmd *Upload) request_manifest(object string, segments []sloSegment, contentType string, header http.Header, deleteAt string) (err error) {
+
+	body, err := json.Marshal(segments)
+	if err != nil {
+		return err
+	}
+
+	uri, err := buildStorageUrl(cmd.config.EndPointUrl, cmd.destContainer, object)
+	if err != nil {
+		return err
+	}
+	uri.RawQuery = "multipart-manifest=put"
+
+	req, err := http.NewRequest("PUT", uri.String(), strings.NewReader(string(body)))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-type", contentType)
+	req.Header.Set("X-Auth-Token", cmd.config.Token)
+
+	// メタデータとヘッダはマニフェストにセットする
+	cmd.addHeaders(req, header)
+	if deleteAt != "" {
+		req.Header.Del("X-Delete-After")
+		req.Header.Set("X-Delete-At", deleteAt)
+	}
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == 404:
+		return errors.New("Container was not found.")
+
+	case resp.StatusCode >= 400:
+		msg := fmt.Sprintf("Return %d status code from the server with message. [%s].",
+			resp.StatusCode,
+			extractErrorMessage(resp.Body),
+		)
+		return errors.New(msg)
+	}
+
+	// マニフェストのETagは各セグメントのETagを連結したもののMD5になる
+	if !cmd.noVerify {
+		hash := md5.New()
+		for _, segment := range segments {
+			io.WriteString(hash, segment.Etag)
+		}
+
+		err = verifyEtag(hex.EncodeToString(hash.Sum(nil)), resp.Header.Get("Etag"))
+		if err != nil {
+			return errors.New(fmt.Sprintf("%s: %v", object, err))
+		}
+	}
+
+	return nil
+}
+
+// アップロード済みのセグメントに有効期限を付ける
+func (cmd *Upload) request_segment_expiry(container string, name string, deleteAt string) (err error) {
+
+	uri, err := buildStorageUrl(cmd.config.EndPointUrl, container, name)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", uri.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Auth-Token", cmd.config.Token)
+	req.Header.Set("X-Delete-At", deleteAt)
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == 404:
+		return errors.New("Segment was not found.")
+
+	case resp.StatusCode >= 400:
+		msg := fmt.Sprintf("Return %d status code from the server with message. [%s].",
+			resp.StatusCode,
+			extractErrorMessage(resp.Body),
+		)
+		return errors.New(msg)
+	}
+
+	return nil
+}
@@ -24,6 +24,9 @@ type Object struct {
 	LastModified  time.Time
 	ETag          string
 
+	// 有効期限(X-Delete-At) 設定されていない場合はゼロ値
+	DeleteAt time.Time
+
 	// メタデータ
 	MetaDatas map[string]string
 
@@ -50,6 +53,10 @@ func (item *Object) String() string {
 	lines = append(lines, fmt.Sprintf(format+"%s", "LastModified", item.LastModified.Format(time.RFC1123)))
 	lines = append(lines, fmt.Sprintf(format+"%s", "ETag", item.ETag))
 
+	if !item.DeleteAt.IsZero() {
+		lines = append(lines, fmt.Sprintf(format+"%s", "Delete At", item.DeleteAt.Format(time.RFC1123)))
+	}
+
 	for name, value := range item.MetaDatas {
 		lines = append(lines, fmt.Sprintf(format+"%s", name, value))
 	}
@@ -136,6 +143,8 @@ func (cmd *Stat) Usage() {
 	fmt.Fprintf(cmd.errStream, `Usage: %s <container or object>
 
 Show informations for container or object.
+If no object has the name, a summary of objects beginning with
+"<name>/" (a pseudo-directory) is shown.
 
 <container or object>  Name of container or object to post to.
 
@@ -149,11 +158,19 @@ func (cmd *Stat) Run() (exitCode int, err error) {
 		return exitCode, err
 	}
 
-	item, err := cmd.Stat(cmd.objectName)
+	// オブジェクトが存在しないパスは、プレフィックスで始まるオブジェクトを集計する
+	item, container, prefix, err := cmd.statOrPrefix(cmd.objectName, false)
 	if err != nil {
 		return ExitCodeError, err
 	}
 
+	if prefix != "" {
+		item, err = cmd.statPrefix(container, prefix)
+		if err != nil {
+			return ExitCodeError, err
+		}
+	}
+
 	// 詳細を出力
 	fmt.Fprintf(cmd.stdStream, item.String())
 
@@ -235,6 +252,13 @@ func (cmd *Stat) populate(path string, headers map[string][]string) (item Item,
 				}
 
 				item.LastModified = d
+			case "X-Delete-At":
+				sec, err := strconv.ParseInt(value[0], 10, 64)
+				if err != nil {
+					return nil, err
+				}
+
+				item.DeleteAt = time.Unix(sec, 0)
 			default:
 				item.MetaDatas[name] = value[0]
 			}
@@ -243,6 +267,9 @@ func (cmd *Stat) populate(path string, headers map[string][]string) (item Item,
 	}
 }
 
+// オブジェクトやコンテナが存在しない(404)
+var errNotFound = errors.New("Object was not found.")
+
 // オブジェクトのヘッダー情報を取得する
 func (cmd *Stat) request(path string) (headers map[string][]string, err error) {
 	u, err := buildStorageUrl(cmd.config.EndPointUrl, path)
@@ -267,7 +294,7 @@ func (cmd *Stat) request(path string) (headers map[string][]string, err error) {
 
 	switch {
 	case resp.StatusCode == 404:
-		return nil, errors.New("Object was not found.")
+		return nil, errNotFound
 	case resp.StatusCode >= 400:
 		msg := fmt.Sprintf("Return %d status code from the server with message. [%s].",
 			resp.StatusCode,
@@ -283,3 +310,53 @@ func (cmd *Stat) request(path string) (headers map[string][]string, err error) {
 
 	return headers, nil
 }
+
+// 対象のオブジェクトの情報を取得する
+// コンテナの場合は中のオブジェクトすべて、オブジェクトが存在しないパスはプレフィックスで始まるオブジェクトすべてを返す
+// recursiveの場合は、オブジェクトに加えてパスをディレクトリとした"<パス>/"で始まるオブジェクトも返す
+func (cmd *Command) statObjects(path string, recursive bool) (objects []*Object, err error) {
+
+	item, container, prefix, err := cmd.statOrPrefix(path, false)
+	if err != nil {
+		return nil, err
+	}
+
+	switch item := item.(type) {
+	case *Container:
+		container = strings.Trim(path, "/")
+
+	case *Object:
+		objects = append(objects, item)
+		if !recursive {
+			return objects, nil
+		}
+
+		// "foo"を指定した場合に"foobar"が含まれないように、プレフィックスは"/"で終わるようにする
+		container, prefix = splitPath(path)
+		prefix = strings.Trim(prefix, "/") + "/"
+	}
+
+	l := NewCommand("list", cmd.config, cmd.stdStream, cmd.errStream).(*List)
+	list, err := l.ListPrefix(container, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(list) == 0 && len(objects) == 0 {
+		return nil, errNotFound
+	}
+
+	s := NewCommand("stat", cmd.config, cmd.stdStream, cmd.errStream).(*Stat)
+	for _, name := range list {
+		item, err := s.Stat(container + "/" + name)
+		if err != nil {
+			return nil, err
+		}
+
+		if object, ok := item.(*Object); ok {
+			objects = append(objects, object)
+		}
+	}
+
+	return objects, nil
+}
@@ -0,0 +1,181 @@
+package command
+
+// 長さがわからないデータ(標準入力など)のアップロード
+//
+// セグメントサイズが指定されていない場合は、チャンク転送でそのままアップロードする。
+// 指定されている場合は、セグメントサイズ分ずつ一時ファイルに溜めてからアップロードし、
+// データがセグメントサイズを超えた時点でStatic Large Objectに切り替える。
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/hironobu-s/conoha-ojs/lib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+)
+
+// 標準入力を表すファイル名
+const STDIN_FILENAME = "-"
+
+// 標準入力からアップロードする
+// 先頭部分を先読みしてContent-typeを判定する
+func (cmd *Upload) request_stdin(reader io.Reader, object string) (err error) {
+	if cmd.encrypt {
+		return cmd.request_encrypt_stream(reader, object, -1, nil)
+	}
+
+	r := bufio.NewReader(reader)
+	head, _ := r.Peek(SNIFF_LENGTH)
+	contentType := cmd.detectContentType(object, head)
+
+	if cmd.gzip && cmd.shouldGzip(contentType) {
+		return cmd.request_gzip_stream(r, object, contentType)
+	}
+
+	return cmd.request_stream(r, object, contentType, nil)
+}
+
+// Readerの内容を最後まで読み込んでアップロードする
+// headerにはオブジェクトごとのメタデータやヘッダを指定する(不要な場合はnil)
+func (cmd *Upload) request_stream(reader io.Reader, object string, contentType string, header http.Header) (err error) {
+	_, err = cmd.request_stream_final(reader, object, contentType, header, nil)
+	return err
+}
+
+// request_streamと同じく、Readerの内容を最後まで読み込んでアップロードする
+// finalには、最後まで読み込んだ後にわかるヘッダ(元のデータのサイズなど)を設定する関数を指定する(不要な場合はnil)
+// 最後のPUT(オブジェクトかマニフェスト)に付けられた場合はfinalizedがtrueになる
+// チャンク転送の場合は読み込みながら送信するので付けられない
+func (cmd *Upload) request_stream_final(reader io.Reader, object string, contentType string, header http.Header, final func(http.Header)) (finalized bool, err error) {
+
+	// X-Delete-Afterはここで時刻にして、セグメントやアップロード後のPOSTと有効期限を揃える
+	header, err = cmd.withDeleteAt(header)
+	if err != nil {
+		return false, err
+	}
+
+	if cmd.segmentSize <= 0 {
+		return false, cmd.request_chunked(reader, object, contentType, header)
+	}
+
+	log := lib.GetLogInstance()
+
+	deleteAt := header.Get("X-Delete-At")
+
+	// セグメントを溜めておく一時ファイル
+	spool, err := ioutil.TempFile("", "conoha-ojs")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	r := bufio.NewReader(reader)
+	prefix := fmt.Sprintf("%s/slo/%d/stream/%d", object, time.Now().Unix(), cmd.segmentSize)
+	segments := []sloSegment{}
+
+	for i := 0; ; i++ {
+		length, err := cmd.fillSpool(spool, r)
+		if err != nil {
+			return false, err
+		}
+
+		// 続きのデータがあるか
+		_, err = r.Peek(1)
+		if err != nil && err != io.EOF {
+			return false, err
+		}
+		hasMore := err == nil
+
+		// 最初のセグメントで終わる場合は、通常のオブジェクトとしてアップロードする
+		if i == 0 && !hasMore {
+			if final != nil {
+				final(header)
+			}
+
+			_, err := cmd.request_object(object, io.NewSectionReader(spool, 0, length), length, contentType, header)
+			if err != nil {
+				return false, err
+			}
+
+			log.Infof("%s (content-type: %s) was uploaded.", object, contentType)
+			cmd.uploaded++
+			return final != nil, nil
+		}
+
+		if i == 0 {
+			err = cmd.request_container(cmd.segmentContainer())
+			if err != nil {
+				return false, err
+			}
+		}
+
+		name := fmt.Sprintf("%s/%08d", prefix, i)
+		etag, err := cmd.request_segment(cmd.segmentContainer(), name, io.NewSectionReader(spool, 0, length), length, deleteAt)
+		if err != nil {
+			return false, err
+		}
+		log.Infof("%s segment %d was uploaded.", object, i+1)
+
+		segments = append(segments, sloSegment{
+			Path:      "/" + cmd.segmentContainer() + "/" + name,
+			Etag:      etag,
+			SizeBytes: length,
+		})
+
+		if !hasMore {
+			break
+		}
+	}
+
+	if final != nil {
+		final(header)
+	}
+
+	err = cmd.request_manifest(object, segments, contentType, header, deleteAt)
+	if err != nil {
+		return false, err
+	}
+
+	log.Infof("%s (content-type: %s, %d segments) was uploaded.", object, contentType, len(segments))
+	cmd.uploaded++
+
+	return final != nil, nil
+}
+
+// 一時ファイルにセグメントサイズ分のデータを書き込み、書き込んだサイズを返す
+func (cmd *Upload) fillSpool(spool *os.File, reader io.Reader) (length int64, err error) {
+	_, err = spool.Seek(0, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	err = spool.Truncate(0)
+	if err != nil {
+		return 0, err
+	}
+
+	length, err = io.CopyN(spool, reader, int64(cmd.segmentSize))
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+
+	return length, nil
+}
+
+// チャンク転送でアップロードする
+func (cmd *Upload) request_chunked(reader io.Reader, object string, contentType string, header http.Header) (err error) {
+	_, err = cmd.request_object(object, reader, -1, contentType, header)
+	if err != nil {
+		return err
+	}
+
+	log := lib.GetLogInstance()
+	log.Infof("%s (content-type: %s) was uploaded.", object, contentType)
+	cmd.uploaded++
+
+	return nil
+}
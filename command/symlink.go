@@ -0,0 +1,226 @@
+package command
+
+// アップロードするディレクトリの走査とシンボリックリンクの扱い
+//
+// filepath.Walkはシンボリックリンクのディレクトリをたどらないため、独自に走査する。
+// シンボリックリンクは--symlinksの指定に従って扱う。
+//
+//   follow:   リンク先のファイルやディレクトリをリンクの名前でアップロードする
+//   skip:     アップロードしない
+//   preserve: クラスタが対応していればSwiftのシンボリックリンク(X-Symlink-Target)を作成する
+//             対応していない場合やリンク先がアップロードするディレクトリの外にある場合は、
+//             空のオブジェクトを作成してリンク先をメタデータに記録する
+
+import (
+	"errors"
+	"fmt"
+	"github.com/hironobu-s/conoha-ojs/lib"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	SYMLINKS_FOLLOW   = "follow"
+	SYMLINKS_SKIP     = "skip"
+	SYMLINKS_PRESERVE = "preserve"
+)
+
+// シンボリックリンクのリンク先を記録するメタデータ
+const META_SYMLINK_TARGET = "X-Object-Meta-Symlink-Target"
+
+func validSymlinksPolicy(policy string) error {
+	switch policy {
+	case SYMLINKS_FOLLOW, SYMLINKS_SKIP, SYMLINKS_PRESERVE:
+		return nil
+	}
+	return errors.New(fmt.Sprintf("Invalid --symlinks \"%s\". It must be follow, skip or preserve.", policy))
+}
+
+// filepath.Walkと同じくrootの配下を辞書順に走査してfnを呼び出す
+// シンボリックリンクはcmd.symlinksに従って扱う
+// preserveの場合、fnにはシンボリックリンク自体のFileInfoを渡す
+// quietの場合は、スキップしたシンボリックリンクをログに出力しない
+func (cmd *Upload) walk(root string, quiet bool, fn filepath.WalkFunc) error {
+	info, err := os.Lstat(root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+
+	err = cmd.walkPath(root, info, nil, quiet, fn)
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+// ancestorsは走査中のディレクトリの親(ループの検出に使う)
+func (cmd *Upload) walkPath(path string, info os.FileInfo, ancestors []os.FileInfo, quiet bool, fn filepath.WalkFunc) error {
+	log := lib.GetLogInstance()
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		switch cmd.symlinks {
+		case SYMLINKS_SKIP:
+			if !quiet {
+				log.Infof("%s is a symbolic link. skipped.", path)
+			}
+			return nil
+
+		case SYMLINKS_PRESERVE:
+			return fn(path, info, nil)
+		}
+
+		// リンク先をたどる
+		target, err := os.Stat(path)
+		if err != nil {
+			if !quiet {
+				log.Warnf("%s is a broken symbolic link. skipped. [%v]", path, err)
+			}
+			return nil
+		}
+		info = target
+	}
+
+	if !info.IsDir() {
+		return fn(path, info, nil)
+	}
+
+	// シンボリックリンクで親ディレクトリに戻る場合はループになる
+	for _, ancestor := range ancestors {
+		if os.SameFile(ancestor, info) {
+			if !quiet {
+				log.Warnf("%s is a symbolic link loop. skipped.", path)
+			}
+			return nil
+		}
+	}
+
+	err := fn(path, info, nil)
+	if err != nil {
+		return err
+	}
+
+	names, err := readDirNames(path)
+	if err != nil {
+		return fn(path, info, err)
+	}
+
+	ancestors = append(ancestors, info)
+	for _, name := range names {
+		filename := filepath.Join(path, name)
+
+		fi, err := os.Lstat(filename)
+		if err != nil {
+			if err = fn(filename, fi, err); err != nil && err != filepath.SkipDir {
+				return err
+			}
+			continue
+		}
+
+		err = cmd.walkPath(filename, fi, ancestors, quiet, fn)
+		if err != nil && err != filepath.SkipDir {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readDirNames(dirname string) ([]string, error) {
+	dir, err := os.Open(dirname)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
+
+// シンボリックリンクをアップロードする
+// baseは引数で指定されたパス(リンク先のオブジェクト名を決めるのに使う)
+func (cmd *Upload) request_symlink(base string, path string, object string) (err error) {
+	log := lib.GetLogInstance()
+
+	target, err := os.Readlink(path)
+	if err != nil {
+		return err
+	}
+
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+
+	header := cmd.attributesHeader(fi)
+	header.Set(META_SYMLINK_TARGET, target)
+
+	targetObject, err := cmd.symlinkTargetObject(base, path, target)
+	if err != nil {
+		log.Infof("%s: %v The link target is stored in the metadata.", path, err)
+
+	} else if !cmd.supportsSymlink() {
+		log.Infof("%s: Symbolic links are not supported by the cluster. The link target is stored in the metadata.", path)
+
+	} else {
+		u := &url.URL{Path: cmd.destContainer + "/" + targetObject}
+		header.Set("X-Symlink-Target", u.EscapedPath())
+	}
+
+	_, err = cmd.request_object(object, http.NoBody, 0, DEFAULT_CONTENT_TYPE, header)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("%s (symbolic link to %s) was uploaded.", path, target)
+	cmd.uploaded++
+
+	return nil
+}
+
+// リンク先のオブジェクト名を返す
+// リンク先がアップロードするディレクトリの外にある場合はエラーを返す
+func (cmd *Upload) symlinkTargetObject(base string, path string, target string) (string, error) {
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(path), target)
+	}
+
+	absBase, err := filepath.Abs(base)
+	if err != nil {
+		return "", err
+	}
+
+	absTarget, err := filepath.Abs(target)
+	if err != nil {
+		return "", err
+	}
+
+	rel, err := filepath.Rel(absBase, absTarget)
+	if err != nil || rel == ".." || len(rel) > 2 && rel[:3] == ".."+string(filepath.Separator) {
+		return "", errors.New("The link target is outside of the uploaded directory.")
+	}
+
+	// 他のファイルと同じ規則でオブジェクト名を決める
+	object, err := buildObjectName(base, filepath.Join(base, rel), cmd.prefix, cmd.stripComponents)
+	if err != nil || object == "" {
+		return "", errors.New("The link target has no object name.")
+	}
+	return object, nil
+}
+
+// クラスタがシンボリックリンクに対応しているか調べる
+// 一度だけ/infoを取得する
+func (cmd *Upload) supportsSymlink() bool {
+	if cmd.symlinkSupported == nil {
+		supported := cmd.hasCapability("symlink")
+		cmd.symlinkSupported = &supported
+	}
+	return *cmd.symlinkSupported
+}
@@ -1,13 +1,17 @@
 package command
 
 import (
+	"crypto/md5"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/hironobu-s/conoha-ojs/lib"
-	"mime"
+	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	flag "github.com/ogier/pflag"
 )
@@ -16,14 +20,78 @@ type Upload struct {
 	srcFiles      []string
 	destContainer string
 
+	// オブジェクト名の指定
+	objectName      string
+	prefix          string
+	stripComponents int
+
 	contentType        string
 	defaultContentType string
+	detector           *contentTypeDetector
+
+	// PUTリクエストに付けるメタデータとヘッダ
+	metadatas strmap
+	headers   headermap
+
+	// パーミッションをメタデータに記録する
+	preserveMode bool
+
+	// シンボリックリンクの扱い(follow, skip, preserve)
+	symlinks         string
+	symlinkSupported *bool
+
+	// extract-archiveで一括アップロードする
+	bulk        bool
+	bulkFormat  string
+	bulkEnabled bool
+	bulkFiles   []bulkFile
+
+	// 分割アップロード
+	segmentSize bytesize
+	resume      bool
+
+	// 変更されていないファイルをスキップする
+	skipIdentical bool
+
+	// ETagによる検証を行わない
+	noVerify bool
+
+	// gzipで圧縮してアップロードする
+	gzip      bool
+	gzipTypes string
+
+	// 暗号化してアップロードする
+	encrypt bool
+	keyFile string
+	keys    *cryptoKeys
+
+	// アップロードするファイルの絞り込み
+	includes strslice
+	excludes strslice
+	maxSize  bytesize
+	minSize  bytesize
+
+	// 実際にはアップロードしない
+	dryRun  bool
+	verbose bool
+
+	// アップロードしたファイル数とスキップしたファイル数
+	uploaded int
+	skipped  int
+
+	// 進捗表示
+	noProgress bool
+	progress   *progress
 
 	*Command
 }
 
 func (cmd *Upload) parseFlags() (exitCode int, err error) {
 
+	// 初期化
+	cmd.metadatas = strmap{}
+	cmd.headers = headermap{}
+
 	var showUsage bool
 
 	fs := flag.NewFlagSet("conoha-ojs-upload", flag.ContinueOnError)
@@ -31,6 +99,32 @@ func (cmd *Upload) parseFlags() (exitCode int, err error) {
 	// コマンドライン引数の定義を追加
 	fs.BoolVarP(&showUsage, "help", "h", false, "Print usage.")
 	fs.StringVarP(&cmd.contentType, "content-type", "c", "", "Set Content-type")
+	fs.StringVarP(&cmd.defaultContentType, "default-content-type", "", DEFAULT_CONTENT_TYPE, "Set Content-type for files that can not be detected.")
+	fs.VarP(&cmd.metadatas, "meta", "m", "Key and value of metadata.")
+	fs.VarP(&cmd.headers, "header", "H", "HTTP header.")
+	fs.StringVarP(&cmd.objectName, "object-name", "o", "", "Set object name.")
+	fs.StringVarP(&cmd.prefix, "prefix", "p", "", "Prepend the path to object names.")
+	fs.IntVarP(&cmd.stripComponents, "strip-components", "", 0, "Strip leading path elements from object names.")
+	fs.BoolVarP(&cmd.preserveMode, "preserve-mode", "", false, "Store file permissions in the metadata.")
+	fs.VarP(&cmd.segmentSize, "segment-size", "S", "Upload files larger than this size as segments.")
+	fs.BoolVarP(&cmd.resume, "resume", "", false, "Resume an interrupted segmented upload.")
+	fs.BoolVarP(&cmd.skipIdentical, "changed", "", false, "Upload only changed files.")
+	fs.BoolVarP(&cmd.skipIdentical, "skip-identical", "", false, "Upload only changed files.")
+	fs.BoolVarP(&cmd.noVerify, "no-verify", "", false, "Do not verify ETag.")
+	fs.BoolVarP(&cmd.gzip, "gzip", "z", false, "Compress files with gzip.")
+	fs.StringVarP(&cmd.gzipTypes, "gzip-types", "", DEFAULT_GZIP_TYPES, "Content-types to compress.")
+	fs.BoolVarP(&cmd.noProgress, "no-progress", "", false, "Do not show progress.")
+	fs.BoolVarP(&cmd.encrypt, "encrypt", "e", false, "Encrypt files.")
+	fs.StringVarP(&cmd.keyFile, "key-file", "", "", "Key file for encryption.")
+	fs.VarP(&cmd.includes, "include", "", "Upload only files matching the pattern.")
+	fs.VarP(&cmd.excludes, "exclude", "", "Exclude files matching the pattern.")
+	fs.VarP(&cmd.maxSize, "max-size", "", "Exclude files larger than the size.")
+	fs.VarP(&cmd.minSize, "min-size", "", "Exclude files smaller than the size.")
+	fs.BoolVarP(&cmd.bulk, "bulk", "", false, "Upload files with extract-archive.")
+	fs.StringVarP(&cmd.bulkFormat, "bulk-format", "", BULK_FORMAT_TAR, "Archive format for --bulk.")
+	fs.StringVarP(&cmd.symlinks, "symlinks", "", SYMLINKS_FOLLOW, "How to handle symbolic links.")
+	fs.BoolVarP(&cmd.dryRun, "dry-run", "n", false, "Show files to be uploaded without uploading.")
+	fs.BoolVarP(&cmd.verbose, "verbose", "v", false, "Show excluded files.")
 
 	err = fs.Parse(os.Args[2:])
 	if err != nil {
@@ -52,6 +146,15 @@ func (cmd *Upload) parseFlags() (exitCode int, err error) {
 	for i := 1; i < fs.NArg(); i++ {
 		filename := fs.Arg(i)
 
+		// 標準入力からアップロードする場合は、オブジェクト名の指定が必要
+		if filename == STDIN_FILENAME {
+			if fs.NArg() != 2 || cmd.objectName == "" {
+				return ExitCodeParseFlagError, errors.New("Uploading from standard input requires only one \"-\" and --object-name.")
+			}
+			cmd.srcFiles = append(cmd.srcFiles, filename)
+			continue
+		}
+
 		_, err := os.Stat(filename)
 		if err != nil {
 			msg := fmt.Sprintf("File \"%s\" not found.", filename)
@@ -61,6 +164,36 @@ func (cmd *Upload) parseFlags() (exitCode int, err error) {
 		cmd.srcFiles = append(cmd.srcFiles, filename)
 	}
 
+	// オブジェクト名は一つのファイルに対してのみ指定できる
+	if cmd.objectName != "" && cmd.srcFiles[0] != STDIN_FILENAME {
+		fi, _ := os.Stat(cmd.srcFiles[0])
+		if len(cmd.srcFiles) != 1 || fi.IsDir() {
+			return ExitCodeParseFlagError, errors.New("--object-name can be used with only one file.")
+		}
+	}
+
+	if cmd.gzip && cmd.encrypt {
+		return ExitCodeParseFlagError, errors.New("--gzip and --encrypt can not be used together.")
+	}
+
+	if cmd.stripComponents < 0 {
+		return ExitCodeParseFlagError, errors.New("--strip-components should be zero or more.")
+	}
+
+	if err = validSymlinksPolicy(cmd.symlinks); err != nil {
+		return ExitCodeParseFlagError, err
+	}
+
+	if cmd.bulk {
+		if cmd.gzip || cmd.encrypt || len(cmd.headers) > 0 {
+			return ExitCodeParseFlagError, errors.New("--bulk can not be used with --gzip, --encrypt or --header.")
+		}
+
+		if cmd.bulkFormat != BULK_FORMAT_TAR && cmd.bulkFormat != BULK_FORMAT_TAR_GZ {
+			return ExitCodeParseFlagError, errors.New("--bulk-format must be tar or tar.gz.")
+		}
+	}
+
 	return ExitCodeOK, nil
 }
 
@@ -71,63 +204,354 @@ Upload files or directories to a container.
 
 <container>          Name of container to upload.
 <file or directory>  Name of file or directory to upload.
+                     If "-" is given, upload data from standard input.
+                     It requires --object-name.
+
+  -c, --content-type: Set Content-type. If not set, Content-type is detected
+                      by the file extension, or by the contents of the file.
+                      Extensions can be mapped to Content-types with
+                      "MimeTypesFile" (mime.types format) in ~/.conoha-ojs.
+
+      --default-content-type:
+                      Set Content-type for files that can not be detected.
+                      Default is "application/octet-stream".
+
+  -m, --meta:         Set a meta data item. This option may be repeated.
+                      Example: -m Hoge:Fuga -m Foo:Bar
+
+  -H, --header:       Set a HTTP header. This option may be repeated.
+                      Example: -H "Content-Disposition: attachment"
+                               -H "Cache-Control: max-age=3600"
+                               -H "X-Delete-After: 86400"
+
+                      The modification time of files is always stored in
+                      the metadata "Mtime", and restored when downloading.
+
+      --preserve-mode:
+                      Store file permissions in the metadata "Mode",
+                      and restore them when downloading.
+
+  -o, --object-name:  Set the object name. It can be used with only one file.
+
+  -p, --prefix:       Prepend the path to object names.
+                      Example: -p backup/2015/
+
+      --strip-components:
+                      Strip the number of leading path elements from object names.
+
+                      Object names are the paths given in arguments.
+                      If the path is absolute or starts with "..", it is relative
+                      to the parent directory of the path. (../data/img.jpg => img.jpg)
+                      If the directory ends with "/", it is relative to the directory.
+                      (/srv/www/ => index.html, /srv/www => www/index.html)
+
+  -S, --segment-size: Upload files larger than this size as segments,
+                      and create a Static Large Object manifest for them.
+                      K, M and G suffixes are allowed. Example: -S 1G
+
+                      When uploading from standard input, data is uploaded
+                      as segments after it exceeds this size. If not set,
+                      it is uploaded with chunked transfer encoding.
+
+      --resume:       Resume an interrupted segmented upload.
+                      Segments already uploaded are verified and skipped.
+
+      --changed,
+      --skip-identical: Skip files that are identical to the objects.
+                        Files are compared by size and ETag(MD5),
+                        or by the mtime stored in the metadata.
+                        With --preserve-mode, files whose permissions
+                        were changed are uploaded again.
+
+      --no-verify:    Do not verify the ETag(MD5) returned from the server.
+
+  -z, --gzip:         Compress files with gzip while uploading.
+                      Objects have "Content-Encoding: gzip" and the original Content-type.
+                      The original size and MD5 are stored in the metadata.
+
+      --gzip-types:   Content-types to compress with --gzip, separated by commas.
+                      Default is "text/*,application/json,application/javascript,application/xml,image/svg+xml".
+
+  -e, --encrypt:      Encrypt files with AES-256-GCM before uploading.
+                      The key is read from --key-file, KeyFile in ~/.conoha-ojs,
+                      or derived from the passphrase in %s.
+
+      --key-file:     Key file for encryption (32 bytes, or 64 hex characters).
+
+      --include:      Upload only files matching the pattern. This option may be repeated.
+      --exclude:      Exclude files matching the pattern. This option may be repeated.
+                      Patterns are the same format as .gitignore.
+                      Example: --exclude .git/ --exclude "*.swp"
+                      Patterns in .ojsignore file in the directory are also excluded.
 
-  -c, --content-type: Set Content-type. If not set, Content-type will be "application/octet-strem".
+      --max-size:     Exclude files larger than the size.
+      --min-size:     Exclude files smaller than the size.
 
-`, lib.COMMAND_NAME)
+      --symlinks:     How to handle symbolic links. Default is "follow".
+                      follow:   Upload the target file or directory as the link name.
+                      skip:     Do not upload symbolic links.
+                      preserve: Create a symlink object (X-Symlink-Target) if the
+                                cluster supports it. Otherwise, or if the target is
+                                outside of the uploaded directory, create an empty
+                                object with the target in the metadata "Symlink-Target".
+                      Symbolic link loops are detected and skipped.
+
+      --bulk:         Upload files in a tar archive with the bulk middleware
+                      (extract-archive) of the cluster, up to %d files per request.
+                      Directories, symbolic links and files larger than
+                      --segment-size are uploaded one by one.
+                      The ETag of each file is not verified.
+                      If the cluster does not support it, files are uploaded one by one.
+
+      --bulk-format:  Archive format for --bulk. "tar" or "tar.gz". Default is "tar".
+
+  -n, --dry-run:      Show files to be uploaded and excluded without uploading.
+
+  -v, --verbose:      Show excluded files.
+
+      --no-progress:  Do not show progress. A summary is shown at the end.
+                      Progress bars are shown when stderr is a terminal,
+                      otherwise progress is logged periodically.
+
+`, lib.COMMAND_NAME, PASSPHRASE_ENV, BULK_UPLOAD_MAX_FILES)
 }
 
 func (cmd *Upload) Run() (exitCode int, err error) {
 	exitCode, err = cmd.parseFlags()
 	if err != nil || exitCode == ExitCodeUsage {
 		cmd.Usage()
-		return exitCode, nil
+		return exitCode, err
+	}
+
+	cmd.detector, err = newContentTypeDetector(cmd.config)
+	if err != nil {
+		return ExitCodeError, err
+	}
+
+	cmd.keys = newCryptoKeys(cmd.config, cmd.keyFile)
+
+	// 一括アップロードに対応していなければ、一つずつアップロードする
+	if cmd.bulk && !cmd.dryRun {
+		cmd.bulkEnabled = cmd.hasCapability("bulk_upload")
+		if !cmd.bulkEnabled {
+			log := lib.GetLogInstance()
+			log.Infof("Bulk upload is not supported by the cluster. Files are uploaded one by one.")
+		}
+	}
+
+	cmd.progress = newProgress(cmd.errStream, "Uploading", !cmd.noProgress && !cmd.dryRun)
+	if !cmd.dryRun {
+		cmd.progress.SetTotal(cmd.scanTotal())
 	}
 
 	for _, filename := range cmd.srcFiles {
-		err = cmd.request(filename)
+		if filename == STDIN_FILENAME && cmd.dryRun {
+			log := lib.GetLogInstance()
+			log.Infof("%s => %s/%s", filename, cmd.destContainer, joinObjectName(cmd.prefix, cmd.objectName))
+			continue
+
+		} else if filename == STDIN_FILENAME {
+			cmd.progress.Start(filename, -1)
+			err = cmd.request_stdin(os.Stdin, joinObjectName(cmd.prefix, cmd.objectName))
+			cmd.progress.Done(err)
+
+		} else {
+			err = cmd.request(filename)
+		}
+
 		if err != nil {
-			return ExitCodeError, err
+			break
 		}
 	}
 
+	cmd.progress.Close()
+
+	log := lib.GetLogInstance()
+	if !cmd.dryRun {
+		log.Infof("Uploaded %s", cmd.progress.Summary())
+	}
+
+	if cmd.skipIdentical {
+		log.Infof("%d files were uploaded, %d files were skipped.", cmd.uploaded, cmd.skipped)
+	}
+
+	if err != nil {
+		return ExitCodeError, err
+	}
+
 	return ExitCodeOK, nil
 }
 
 // Content-typeを決定する
-func (cmd *Upload) detectContentType(filename string) (contentType string) {
+// headはデータの先頭部分で、拡張子から判定できない場合に使う
+func (cmd *Upload) detectContentType(name string, head []byte) (contentType string) {
 
 	// 指定がある場合はそれを使う
 	if cmd.contentType != "" {
 		return cmd.contentType
 	}
 
-	ext := filepath.Ext(filename)
-
-	contentType = mime.TypeByExtension(ext)
+	contentType = cmd.detector.Detect(name, head)
 	if contentType == "" {
 		contentType = cmd.defaultContentType
 	}
 	return contentType
 }
 
+// ファイルのContent-typeを決定する
+// 拡張子から判定できない場合は、ファイルの先頭部分を読み込んで判定する
+func (cmd *Upload) detectFileContentType(filename string) (contentType string) {
+	if cmd.contentType != "" {
+		return cmd.contentType
+	}
+
+	if contentType = cmd.detector.ByName(filename); contentType != "" {
+		return contentType
+	}
+
+	head, err := readHead(filename)
+	if err != nil {
+		log := lib.GetLogInstance()
+		log.Debugf("Cannot read %s to detect Content-type. [%v]", filename, err)
+	}
+
+	return cmd.detectContentType(filename, head)
+}
+
 func (cmd *Upload) request(pathname string) (err error) {
+	log := lib.GetLogInstance()
+
+	filter, err := cmd.newFilter(pathname)
+	if err != nil {
+		return err
+	}
 
 	// ディレクトリ走査する
-	return filepath.Walk(pathname,
+	err = cmd.walk(pathname, false,
 		func(path string, info os.FileInfo, err error) error {
-			if info.IsDir() {
-				return cmd.request_dir(path)
+			if err != nil {
+				return err
+			}
+
+			// 除外するファイル
+			if excluded, reason := filter.excluded(filterPath(pathname, path, info), info); excluded {
+				if cmd.dryRun || cmd.verbose {
+					log.Infof("%s was excluded. (%s)", path, reason)
+				} else {
+					log.Debugf("%s was excluded. (%s)", path, reason)
+				}
+
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			object, err := cmd.buildObjectName(pathname, path)
+			if err != nil {
+				return err
+			}
+
+			// オブジェクト名が空になる場合はアップロードしない
+			if object == "" {
+				if !info.IsDir() {
+					log.Warnf("%s was skipped. Object name is empty.", path)
+				}
+				return nil
+			}
+
+			if cmd.dryRun {
+				log.Infof("%s => %s/%s", path, cmd.destContainer, object)
+				return nil
+			}
+
+			if cmd.verbose {
+				log.Infof("Uploading %s => %s/%s", path, cmd.destContainer, object)
 			} else {
-				return cmd.request_file(path)
+				log.Debugf("Uploading %s => %s/%s", path, cmd.destContainer, object)
+			}
+
+			switch {
+			case info.IsDir():
+				return cmd.request_dir(path, object)
+
+			case info.Mode()&os.ModeSymlink != 0:
+				cmd.progress.Start(path, 0)
+				err = cmd.request_symlink(pathname, path, object)
+				cmd.progress.Done(err)
+				return err
+
+			case cmd.bulkEnabled && cmd.bulkable(info):
+				// 走査が終わってからまとめてアップロードする
+				cmd.bulkFiles = append(cmd.bulkFiles, bulkFile{path: path, object: object, info: info})
+				return nil
+
+			default:
+				return cmd.request_file(path, object)
 			}
 		})
+
+	if err != nil || !cmd.bulkEnabled {
+		return err
+	}
+
+	return cmd.flush_bulk()
 }
 
-func (cmd *Upload) request_dir(dirname string) (err error) {
+// アップロードするファイルの数と合計サイズを数える
+// 全体の進捗の表示に使うだけなので、エラーは無視する
+func (cmd *Upload) scanTotal() (files int, bytes int64) {
+	for _, pathname := range cmd.srcFiles {
+		if pathname == STDIN_FILENAME {
+			continue
+		}
+
+		filter, err := cmd.newFilter(pathname)
+		if err != nil {
+			continue
+		}
+
+		// ログはアップロードの走査で出力するので、ここでは出力しない
+		cmd.walk(pathname, true,
+			func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return nil
+				}
+
+				if excluded, _ := filter.excluded(filterPath(pathname, path, info), info); excluded {
+					if info.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+
+				switch {
+				case info.IsDir():
+				case info.Mode()&os.ModeSymlink != 0:
+					files++
+				default:
+					files++
+					bytes += info.Size()
+				}
+				return nil
+			})
+	}
+
+	return files, bytes
+}
+
+// ファイルのパスからオブジェクト名を決める
+func (cmd *Upload) buildObjectName(base string, path string) (string, error) {
+	if cmd.objectName != "" {
+		return joinObjectName(cmd.prefix, cmd.objectName), nil
+	}
+	return buildObjectName(base, path, cmd.prefix, cmd.stripComponents)
+}
+
+func (cmd *Upload) request_dir(dirname string, object string) (err error) {
 
 	// アップロード先のURIを準備
-	uri, err := buildStorageUrl(cmd.config.EndPointUrl, cmd.destContainer, dirname)
+	uri, err := buildStorageUrl(cmd.config.EndPointUrl, cmd.destContainer, object)
 	if err != nil {
 		return err
 	}
@@ -138,7 +562,7 @@ func (cmd *Upload) request_dir(dirname string) (err error) {
 		return err
 	}
 
-	req.Header.Set("Content-type", "application/directory")
+	req.Header.Set("Content-type", DIRECTORY_CONTENT_TYPE)
 	req.Header.Set("X-Auth-Token", cmd.config.Token)
 
 	// リクエストを実行
@@ -166,51 +590,225 @@ func (cmd *Upload) request_dir(dirname string) (err error) {
 	return nil
 }
 
-func (cmd *Upload) request_file(filename string) (err error) {
+// ファイルをアップロードする
+// 変更されていないファイルのスキップと、進捗の表示もここで行う
+func (cmd *Upload) request_file(filename string, object string) (err error) {
+
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+
+	// 変更されていないファイルはスキップする
+	if cmd.skipIdentical && cmd.isIdentical(filename, object, fi) {
+		log := lib.GetLogInstance()
+		log.Infof("%s is not changed. skipped.", filename)
+		cmd.skipped++
+		cmd.progress.SkipFile(fi.Size())
+		return nil
+	}
+
+	cmd.progress.Start(filename, fi.Size())
+	err = cmd.upload_file(filename, object, fi)
+	cmd.progress.Done(err)
+
+	return err
+}
+
+// ファイルの種類やオプションに応じた方法でアップロードする
+func (cmd *Upload) upload_file(filename string, object string, fi os.FileInfo) (err error) {
+
+	if cmd.encrypt {
+		return cmd.request_encrypt(filename, object, fi)
+	}
+
+	// 圧縮する場合はサイズがわからないので、ストリームとしてアップロードする
+	if cmd.gzip {
+		contentType := cmd.detectFileContentType(filename)
+		if cmd.shouldGzip(contentType) {
+			return cmd.request_gzip(filename, object, fi, contentType)
+		}
+	}
+
+	// セグメントサイズより大きいファイルは分割してアップロードする
+	if cmd.segmentSize > 0 && fi.Size() > int64(cmd.segmentSize) {
+		err = cmd.request_segmented(filename, object, fi)
+		if err != nil {
+			return err
+		}
+		cmd.uploaded++
+		return nil
+	}
 
 	// アップロードするファイルへのReaderを作成
 	file, err := os.OpenFile(filename, os.O_RDONLY, 0600)
 	if err != nil {
 		return err
 	}
+	defer file.Close()
 
-	// アップロード先のURIを準備
-	uri, err := buildStorageUrl(cmd.config.EndPointUrl, cmd.destContainer, filename)
+	contentType := cmd.detectFileContentType(filename)
+	_, err = cmd.request_object(object, file, fi.Size(), contentType, cmd.attributesHeader(fi))
 	if err != nil {
 		return err
 	}
 
-	// PUTリクエストを作成
-	req, err := http.NewRequest("PUT", uri.String(), file)
+	log := lib.GetLogInstance()
+	log.Infof("%s (content-type: %s) was uploaded.", filename, contentType)
+	cmd.uploaded++
+
+	return nil
+}
+
+// Readerの内容をオブジェクトとしてアップロードして、ETagを返す
+// lengthが負の場合はチャンク転送になる
+// headerにはオブジェクトごとのメタデータやヘッダを指定する(不要な場合はnil)
+func (cmd *Upload) request_object(object string, reader io.Reader, length int64, contentType string, header http.Header) (etag string, err error) {
+
+	uri, err := buildStorageUrl(cmd.config.EndPointUrl, cmd.destContainer, object)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	contentType := cmd.detectContentType(filename)
+	// 送信しながらMD5を計算する
+	hash := md5.New()
+
+	req, err := http.NewRequest("PUT", uri.String(), io.TeeReader(cmd.progress.Reader(reader), hash))
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = length
+
 	req.Header.Set("Content-type", contentType)
 	req.Header.Set("X-Auth-Token", cmd.config.Token)
 
-	// リクエストを実行
+	// メタデータとヘッダをセットする
+	cmd.addHeaders(req, header)
+
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		return err
+		return "", err
 	}
+	defer resp.Body.Close()
 
 	switch {
 	case resp.StatusCode == 404:
-		return errors.New("Container was not found.")
+		return "", errors.New("Container was not found.")
 
 	case resp.StatusCode >= 400:
 		msg := fmt.Sprintf("Return %d status code from the server with message. [%s].",
 			resp.StatusCode,
 			extractErrorMessage(resp.Body),
 		)
-		return errors.New(msg)
+		return "", errors.New(msg)
 	}
 
+	etag = resp.Header.Get("Etag")
+	if !cmd.noVerify {
+		err = verifyEtag(hex.EncodeToString(hash.Sum(nil)), etag)
+		if err != nil {
+			return "", errors.New(fmt.Sprintf("%s: %v", object, err))
+		}
+	}
+
+	return etag, nil
+}
+
+// 引数で指定されたメタデータとヘッダ、オブジェクトごとのヘッダを、PUTリクエストに追加する
+func (cmd *Upload) addHeaders(req *http.Request, header http.Header) {
 	log := lib.GetLogInstance()
-	log.Infof("%s (content-type: %s) was uploaded.", filename, contentType)
 
-	return nil
+	for name, value := range cmd.metadatas {
+		header := "X-Object-Meta-" + name
+		req.Header.Set(header, value)
+
+		log.Debugf("Set meta data: %s=%s", header, value)
+	}
+
+	for name, value := range cmd.headers {
+		req.Header.Set(name, value)
+
+		log.Debugf("Set header: %s=%s", name, value)
+	}
+
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	// オブジェクトごとに有効期限を時刻にした場合は、そちらを使う
+	// (Swiftは両方指定されるとX-Delete-Afterを優先する)
+	if header.Get("X-Delete-At") != "" {
+		req.Header.Del("X-Delete-After")
+	}
+}
+
+// ローカルのファイルとオブジェクトが同一か調べる
+// サイズが一致して、メタデータのmtimeかETag(MD5)が一致すれば同一とみなす
+// オブジェクトが存在しない場合や、比較できない場合は同一でないとする
+func (cmd *Upload) isIdentical(filename string, object string, fi os.FileInfo) bool {
+	log := lib.GetLogInstance()
+
+	s := NewCommand("stat", cmd.config, cmd.stdStream, cmd.errStream).(*Stat)
+	item, err := s.Stat(cmd.destContainer + "/" + object)
+	if err != nil {
+		return false
+	}
+
+	obj, ok := item.(*Object)
+	if !ok {
+		return false
+	}
+
+	// 暗号化されたオブジェクトは、平文のサイズとメタデータのmtimeで比較する
+	if params, _ := cryptoParamsFromMetaDatas(obj.MetaDatas); params != nil {
+		size, err := params.PlainSize(int64(obj.ContentLength))
+		if err != nil || size != fi.Size() {
+			return false
+		}
+
+		same, _ := cmd.sameAttributes(obj.MetaDatas, fi)
+		return same
+	}
+
+	// 圧縮されたオブジェクトは、メタデータに記録された元のサイズとMD5で比較する
+	if size, ok := obj.MetaDatas[META_ORIGINAL_SIZE]; ok {
+		if size != strconv.FormatInt(fi.Size(), 10) {
+			return false
+		}
+
+		sum, err := md5File(filename)
+		if err != nil {
+			log.Debugf("Cannot calculate MD5 of %s. [%v]", filename, err)
+			return false
+		}
+		return sum == obj.MetaDatas[META_ORIGINAL_MD5]
+	}
+
+	if int64(obj.ContentLength) != fi.Size() {
+		return false
+	}
+
+	// mtimeが記録されていれば、ハッシュを計算せずに比較できる
+	if same, ok := cmd.sameAttributes(obj.MetaDatas, fi); ok {
+		return same
+	}
+
+	// Static Large ObjectのETagは、同じセグメントサイズで分割した場合のみ比較できる
+	var etag string
+	if _, ok := obj.MetaDatas["X-Static-Large-Object"]; ok {
+		if cmd.segmentSize <= 0 {
+			return false
+		}
+		etag, err = md5FileSegmented(filename, int64(cmd.segmentSize))
+	} else {
+		etag, err = md5File(filename)
+	}
+
+	if err != nil {
+		log.Debugf("Cannot calculate MD5 of %s. [%v]", filename, err)
+		return false
+	}
+
+	return etag == strings.Trim(obj.ETag, `"`)
 }
@@ -1,35 +1,53 @@
 package command
 
 import (
+	"errors"
+	"fmt"
 	"github.com/hironobu-s/conoha-ojs/lib"
 	"io"
 	"io/ioutil"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // 引数で渡された文字列を解決して、オブジェクトストレージのURIを返す
-func buildStorageUrl(endpointUrl string, paths ...string) (url *url.URL, err error) {
+// オブジェクト名の"?"や"%"などはURLのパスとしてエスケープする
+func buildStorageUrl(endpointUrl string, paths ...string) (u *url.URL, err error) {
 	log := lib.GetLogInstance()
 
 	// オブジェクトストレージのURIを構築する
-	rawurl := endpointUrl
+	u, err = url.Parse(endpointUrl)
+	if err != nil {
+		return nil, err
+	}
 
 	// EndPointUrl の末尾のスラッシュを削除
-	if strings.HasSuffix(rawurl, "/") {
-		rawurl = rawurl[0 : len(rawurl)-1]
-	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	u.RawPath = ""
 
 	// パスを連結する先頭のスラッシュを補完
 	for i := 0; i < len(paths); i++ {
 		paths[i] = strings.Trim(paths[i], "/")
 	}
 
-	rawurl += "/" + strings.Join(paths, "/")
+	u.Path += "/" + strings.Join(paths, "/")
 
-	log.Debug(rawurl)
+	log.Debug(u.String())
 
-	return url.Parse(rawurl)
+	return u, nil
+}
+
+// "コンテナ/オブジェクト" の形式のパスを、コンテナ名とオブジェクト名に分ける
+func splitPath(path string) (container string, object string) {
+	path = strings.TrimLeft(path, "/")
+
+	i := strings.Index(path, "/")
+	if i < 0 {
+		return path, ""
+	}
+	return path[:i], path[i+1:]
 }
 
 // オブジェクトストレージが返すエラーレスポンスのHTMLデータからメッセージ部分を抜き出す
@@ -51,3 +69,129 @@ func extractErrorMessage(Body io.ReadCloser) string {
 
 	return rawhtml[pb+3 : pe]
 }
+
+// 引数でサイズを受け取れるようにする
+// 数値のみの場合はバイト数、K, M, G, T の単位を付けることもできる(1024倍ずつ)
+type bytesize int64
+
+func (b *bytesize) String() string {
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *bytesize) Set(arg string) error {
+	size, err := parseSize(arg)
+	if err != nil {
+		return err
+	}
+
+	*b = bytesize(size)
+	return nil
+}
+
+// 100K, 10M, 2Gなどのサイズ表記をバイト数に変換する
+func parseSize(arg string) (size int64, err error) {
+
+	units := map[string]int64{
+		"K": 1 << 10,
+		"M": 1 << 20,
+		"G": 1 << 30,
+		"T": 1 << 40,
+	}
+
+	str := strings.ToUpper(strings.TrimSpace(arg))
+	str = strings.TrimSuffix(str, "B")
+
+	multiplier := int64(1)
+	if len(str) > 0 {
+		if m, ok := units[str[len(str)-1:]]; ok {
+			multiplier = m
+			str = str[:len(str)-1]
+		}
+	}
+
+	size, err = strconv.ParseInt(str, 10, 64)
+	if err != nil || size < 0 {
+		return 0, errors.New(fmt.Sprintf("\"%s\" is invalid size.", arg))
+	}
+
+	return size * multiplier, nil
+}
+
+// 引数で複数の値を受け取れるようにする
+// 指定された順序のまま保持する
+type strslice []string
+
+func (s *strslice) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *strslice) Set(arg string) error {
+	*s = append(*s, arg)
+	return nil
+}
+
+// 引数-Hで複数のHTTPヘッダを受け取れるようにする
+// ヘッダは "Name: value" の形式で渡す
+type headermap map[string]string
+
+func (h *headermap) String() string {
+	return ""
+}
+
+func (h *headermap) Set(arg string) error {
+
+	namevalue := strings.SplitN(arg, ":", 2)
+	if len(namevalue) != 2 || strings.TrimSpace(namevalue[0]) == "" {
+		return errors.New(fmt.Sprintf("\"%s\" is invalid header.", arg))
+	}
+
+	hmap := *h
+	hmap[strings.TrimSpace(namevalue[0])] = strings.TrimSpace(namevalue[1])
+
+	return nil
+}
+
+// 24h, 30m などの期間を秒単位で返す
+// time.ParseDuration の形式に加えて、日数(7d)と秒数のみの数値も受け付ける
+func parseDuration(arg string) (time.Duration, error) {
+	str := strings.TrimSpace(arg)
+
+	if sec, err := strconv.ParseInt(str, 10, 64); err == nil {
+		return time.Duration(sec) * time.Second, nil
+	}
+
+	if strings.HasSuffix(str, "d") {
+		days, err := strconv.ParseInt(strings.TrimSuffix(str, "d"), 10, 64)
+		if err == nil {
+			return time.Duration(days) * 24 * time.Hour, nil
+		}
+	}
+
+	d, err := time.ParseDuration(str)
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("\"%s\" is invalid duration.", arg))
+	}
+	return d, nil
+}
+
+// 日時を返す
+// UNIX時間、RFC3339、"2006-01-02 15:04:05"、"2006-01-02" の形式を受け付ける(タイムゾーンがない場合はローカル時刻)
+func parseTime(arg string) (time.Time, error) {
+	str := strings.TrimSpace(arg)
+
+	if sec, err := strconv.ParseInt(str, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, str); err == nil {
+		return t, nil
+	}
+
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, str, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, errors.New(fmt.Sprintf("\"%s\" is invalid time.", arg))
+}
@@ -32,6 +32,13 @@ type Config struct {
 	ApiPassword string
 	TenantId    string
 	EndPointUrl string
+
+	// 拡張子とMIMEタイプの対応を記述したファイル(mime.types形式)
+	// アップロード時のContent-Typeの判定に使う
+	MimeTypesFile string
+
+	// 暗号化に使う鍵ファイル
+	KeyFile string
 }
 
 func init() {
@@ -0,0 +1,438 @@
+package lib
+
+// オブジェクトの暗号化
+//
+// 平文をCRYPTO_CHUNK_SIZEごとのチャンクに分け、それぞれをAES-256-GCMで暗号化する。
+// 暗号文はチャンクごとに16バイトの認証タグが付くだけなので、平文の位置から暗号文の位置を計算できる。
+// これにより、ラージオブジェクトのセグメント分割やRangeリクエストと組み合わせることができる。
+//
+// オブジェクトごとにランダムなソルトを生成し、マスターキーとのHMAC-SHA256をオブジェクトの鍵にする。
+// ノンス(12バイト)は先頭3バイトを0、続く8バイトをチャンク番号(ビッグエンディアン)、
+// 最後の1バイトを最後のチャンクを示すフラグにして、チャンクの入れ替えや削除、末尾の切り詰めを検出できる。
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+const (
+	CRYPTO_ALGORITHM  = "AES-256-GCM-CHUNKED"
+	CRYPTO_CHUNK_SIZE = 64 * 1024
+	CRYPTO_KEY_SIZE   = 32
+	CRYPTO_SALT_SIZE  = 32
+
+	// パスフレーズから鍵を作る場合のPBKDF2の繰り返し回数
+	PBKDF2_ITERATIONS = 100000
+
+	// 認証タグのサイズ
+	cryptoTagSize = 16
+)
+
+// マスターキー
+type CryptoKey struct {
+	key []byte
+
+	// 鍵の作り方 ("raw" または "pbkdf2-sha256:<繰り返し回数>:<ソルト>")
+	Kdf string
+}
+
+// 鍵ファイルを読み込む
+// 鍵ファイルは32バイトのバイナリか、64文字の16進数とする
+func ReadKeyFile(path string) (*CryptoKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if text := strings.TrimSpace(string(data)); len(text) == CRYPTO_KEY_SIZE*2 {
+		if key, err := hex.DecodeString(text); err == nil {
+			return &CryptoKey{key: key, Kdf: "raw"}, nil
+		}
+	}
+
+	if len(data) != CRYPTO_KEY_SIZE {
+		return nil, errors.New(fmt.Sprintf("%s is not a valid key file. It should be %d bytes or %d hex characters.", path, CRYPTO_KEY_SIZE, CRYPTO_KEY_SIZE*2))
+	}
+
+	return &CryptoKey{key: data, Kdf: "raw"}, nil
+}
+
+// パスフレーズからランダムなソルトで鍵を作る
+func NewPassphraseKey(passphrase string) (*CryptoKey, error) {
+	salt := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+
+	kdf := fmt.Sprintf("pbkdf2-sha256:%d:%s", PBKDF2_ITERATIONS, hex.EncodeToString(salt))
+	return DerivePassphraseKey(passphrase, kdf)
+}
+
+// パスフレーズと、暗号化したときの鍵の作り方から鍵を作る
+func DerivePassphraseKey(passphrase string, kdf string) (*CryptoKey, error) {
+	params := strings.Split(kdf, ":")
+	if len(params) != 3 || params[0] != "pbkdf2-sha256" {
+		return nil, errors.New(fmt.Sprintf("Unsupported key derivation [%s].", kdf))
+	}
+
+	iter, err := strconv.Atoi(params[1])
+	if err != nil || iter <= 0 {
+		return nil, errors.New(fmt.Sprintf("Invalid key derivation [%s].", kdf))
+	}
+
+	salt, err := hex.DecodeString(params[2])
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Invalid key derivation [%s].", kdf))
+	}
+
+	return &CryptoKey{
+		key: pbkdf2([]byte(passphrase), salt, iter, CRYPTO_KEY_SIZE, sha256.New),
+		Kdf: kdf,
+	}, nil
+}
+
+// 鍵を識別するID
+// 復号する前に、正しい鍵か確認するために使う
+func (k *CryptoKey) Id() string {
+	mac := hmac.New(sha256.New, k.key)
+	mac.Write([]byte("conoha-ojs key id"))
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// ソルトからオブジェクトの鍵を作る
+func (k *CryptoKey) objectKey(salt []byte) []byte {
+	mac := hmac.New(sha256.New, k.key)
+	mac.Write(salt)
+	return mac.Sum(nil)
+}
+
+// 暗号化したオブジェクトに記録するパラメータ
+type CryptoParams struct {
+	Algorithm string
+	KeyId     string
+	Kdf       string
+	Salt      []byte
+	ChunkSize int64
+}
+
+// 新しいオブジェクトを暗号化するパラメータを作る
+func NewCryptoParams(key *CryptoKey) (*CryptoParams, error) {
+	salt := make([]byte, CRYPTO_SALT_SIZE)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+
+	return &CryptoParams{
+		Algorithm: CRYPTO_ALGORITHM,
+		KeyId:     key.Id(),
+		Kdf:       key.Kdf,
+		Salt:      salt,
+		ChunkSize: CRYPTO_CHUNK_SIZE,
+	}, nil
+}
+
+// 鍵とアルゴリズムが復号に使えるか確認する
+func (p *CryptoParams) Check(key *CryptoKey) error {
+	if p.Algorithm != CRYPTO_ALGORITHM {
+		return errors.New(fmt.Sprintf("Unsupported encryption algorithm [%s].", p.Algorithm))
+	}
+
+	if p.ChunkSize <= 0 || len(p.Salt) == 0 {
+		return errors.New("Invalid encryption parameters.")
+	}
+
+	if p.KeyId != key.Id() {
+		return errors.New(fmt.Sprintf("The key does not match. (key id: %s, object key id: %s)", key.Id(), p.KeyId))
+	}
+
+	return nil
+}
+
+func (p *CryptoParams) aead(key *CryptoKey) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key.objectKey(p.Salt))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// 暗号化したチャンクのサイズ
+func (p *CryptoParams) cipherChunkSize() int64 {
+	return p.ChunkSize + cryptoTagSize
+}
+
+// 平文のサイズから暗号文のサイズを計算する
+func (p *CryptoParams) CipherSize(plainSize int64) int64 {
+	chunks := (plainSize + p.ChunkSize - 1) / p.ChunkSize
+	if chunks == 0 {
+		// 空のデータも最後のチャンクとして暗号化する
+		chunks = 1
+	}
+	return plainSize + chunks*cryptoTagSize
+}
+
+// 暗号文のサイズから平文のサイズを計算する
+func (p *CryptoParams) PlainSize(cipherSize int64) (int64, error) {
+	chunks := cipherSize / p.cipherChunkSize()
+	rest := cipherSize % p.cipherChunkSize()
+
+	if rest > 0 && rest < cryptoTagSize || cipherSize < cryptoTagSize {
+		return 0, errors.New("Invalid size of encrypted data.")
+	}
+
+	size := chunks * p.ChunkSize
+	if rest > 0 {
+		size += rest - cryptoTagSize
+	}
+	return size, nil
+}
+
+// 平文の範囲(start〜endバイト目)を復号するために必要な、暗号文の範囲を計算する
+// 暗号文はチャンク単位で取得するので、復号したデータの先頭からskipバイトを読み飛ばす
+func (p *CryptoParams) CipherRange(start int64, end int64, cipherSize int64) (cipherStart int64, cipherEnd int64, firstChunk int64, skip int64) {
+	firstChunk = start / p.ChunkSize
+	lastChunk := end / p.ChunkSize
+
+	cipherStart = firstChunk * p.cipherChunkSize()
+	cipherEnd = (lastChunk+1)*p.cipherChunkSize() - 1
+	if cipherEnd >= cipherSize {
+		cipherEnd = cipherSize - 1
+	}
+
+	skip = start - firstChunk*p.ChunkSize
+	return cipherStart, cipherEnd, firstChunk, skip
+}
+
+// 暗号文のサイズから最後のチャンクの番号を計算する
+func (p *CryptoParams) LastChunk(cipherSize int64) int64 {
+	if cipherSize <= 0 {
+		return 0
+	}
+	return (cipherSize - 1) / p.cipherChunkSize()
+}
+
+// チャンクのノンス
+// 末尾の1バイトが最後のチャンクを示すフラグ、その前の8バイトがチャンク番号(ビッグエンディアン)で、残りは0
+func chunkNonce(size int, index int64, last bool) []byte {
+	nonce := make([]byte, size)
+	binary.BigEndian.PutUint64(nonce[size-9:size-1], uint64(index))
+	if last {
+		nonce[size-1] = 1
+	}
+	return nonce
+}
+
+// 読み込んだデータを暗号化するReader
+type encryptReader struct {
+	reader io.Reader
+	aead   cipher.AEAD
+	params *CryptoParams
+
+	index int64
+	plain []byte
+	buf   []byte
+	done  bool
+}
+
+// Readerの内容を暗号化しながら読み込むReaderを返す
+func NewEncryptReader(reader io.Reader, key *CryptoKey, params *CryptoParams) (io.Reader, error) {
+	aead, err := params.aead(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &encryptReader{
+		reader: reader,
+		aead:   aead,
+		params: params,
+		// 最後のチャンクか判断するため、1バイト多く読み込む
+		plain: make([]byte, 0, params.ChunkSize+1),
+	}, nil
+}
+
+func (r *encryptReader) Read(p []byte) (n int, err error) {
+	for len(r.buf) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+
+		if err = r.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n = copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// 次のチャンクを暗号化する
+func (r *encryptReader) next() error {
+	size := r.params.ChunkSize
+
+	m, err := io.ReadFull(r.reader, r.plain[len(r.plain):size+1])
+	r.plain = r.plain[:len(r.plain)+m]
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+
+	last := int64(len(r.plain)) <= size
+	chunk := r.plain
+	if !last {
+		chunk = r.plain[:size]
+	}
+
+	nonce := chunkNonce(r.aead.NonceSize(), r.index, last)
+	r.buf = r.aead.Seal(r.buf[:0], nonce, chunk, nil)
+	r.index++
+
+	if last {
+		r.done = true
+		r.plain = r.plain[:0]
+	} else {
+		// 先読みした1バイトを次のチャンクに回す
+		r.plain = append(r.plain[:0], r.plain[size])
+	}
+
+	return nil
+}
+
+// 読み込んだデータを復号するReader
+type decryptReader struct {
+	reader io.Reader
+	aead   cipher.AEAD
+	params *CryptoParams
+
+	index     int64
+	lastChunk int64
+	cipher    []byte
+	buf       []byte
+	done      bool
+}
+
+// Readerの内容を復号しながら読み込むReaderを返す
+// firstChunkは読み込む暗号文の最初のチャンクの番号(Rangeリクエストで途中から取得した場合)
+// lastChunkはオブジェクトの最後のチャンクの番号で、負の場合はデータの終端を最後のチャンクとみなす
+func NewDecryptReader(reader io.Reader, key *CryptoKey, params *CryptoParams, firstChunk int64, lastChunk int64) (io.Reader, error) {
+	if err := params.Check(key); err != nil {
+		return nil, err
+	}
+
+	aead, err := params.aead(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &decryptReader{
+		reader:    reader,
+		aead:      aead,
+		params:    params,
+		index:     firstChunk,
+		lastChunk: lastChunk,
+		cipher:    make([]byte, 0, params.cipherChunkSize()+1),
+	}, nil
+}
+
+func (r *decryptReader) Read(p []byte) (n int, err error) {
+	for len(r.buf) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+
+		if err = r.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n = copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// 次のチャンクを復号する
+func (r *decryptReader) next() error {
+	size := r.params.cipherChunkSize()
+
+	m, err := io.ReadFull(r.reader, r.cipher[len(r.cipher):size+1])
+	r.cipher = r.cipher[:len(r.cipher)+m]
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+
+	end := int64(len(r.cipher)) <= size
+	chunk := r.cipher
+	if !end {
+		chunk = r.cipher[:size]
+	}
+
+	if end && len(chunk) == 0 {
+		// 最後のチャンクまで読み込む前にデータが終わった
+		return errors.New("Encrypted data is truncated.")
+	}
+
+	last := end
+	if r.lastChunk >= 0 {
+		last = r.index == r.lastChunk
+	}
+
+	nonce := chunkNonce(r.aead.NonceSize(), r.index, last)
+	plain, err := r.aead.Open(r.buf[:0], nonce, chunk, nil)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Cannot decrypt chunk %d. The data may be corrupted or truncated.", r.index))
+	}
+	r.buf = plain
+	r.index++
+
+	if end || last {
+		r.done = true
+		r.cipher = r.cipher[:0]
+	} else {
+		r.cipher = append(r.cipher[:0], r.cipher[size])
+	}
+
+	return nil
+}
+
+// PBKDF2 (RFC 2898)
+func pbkdf2(password []byte, salt []byte, iter int, keyLen int, h func() hash.Hash) []byte {
+	prf := hmac.New(h, password)
+	hashLen := prf.Size()
+	blocks := (keyLen + hashLen - 1) / hashLen
+
+	key := make([]byte, 0, blocks*hashLen)
+	buf := make([]byte, 4)
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(buf, uint32(block))
+		prf.Write(buf)
+		u := prf.Sum(nil)
+
+		t := make([]byte, len(u))
+		copy(t, u)
+
+		for i := 1; i < iter; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+
+	return key[:keyLen]
+}
@@ -0,0 +1,195 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func testKey() *CryptoKey {
+	key := make([]byte, CRYPTO_KEY_SIZE)
+	rand.Read(key)
+	return &CryptoKey{key: key, Kdf: "raw"}
+}
+
+func encrypt(t *testing.T, key *CryptoKey, params *CryptoParams, plain []byte) []byte {
+	r, err := NewEncryptReader(bytes.NewReader(plain), key, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	key := testKey()
+
+	for _, size := range []int{0, 1, CRYPTO_CHUNK_SIZE - 1, CRYPTO_CHUNK_SIZE, CRYPTO_CHUNK_SIZE + 1, CRYPTO_CHUNK_SIZE*3 + 100} {
+		plain := make([]byte, size)
+		rand.Read(plain)
+
+		params, err := NewCryptoParams(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data := encrypt(t, key, params, plain)
+		if int64(len(data)) != params.CipherSize(int64(size)) {
+			t.Errorf("size %d: cipher size should be %d, but %d", size, params.CipherSize(int64(size)), len(data))
+		}
+
+		plainSize, err := params.PlainSize(int64(len(data)))
+		if err != nil || plainSize != int64(size) {
+			t.Errorf("size %d: plain size should be %d, but %d", size, size, plainSize)
+		}
+
+		r, err := NewDecryptReader(bytes.NewReader(data), key, params, 0, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decrypted, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Errorf("size %d: %v", size, err)
+		}
+
+		if !bytes.Equal(plain, decrypted) {
+			t.Errorf("size %d: decrypted data does not match", size)
+		}
+	}
+}
+
+func TestDecryptErrors(t *testing.T) {
+	key := testKey()
+	params, _ := NewCryptoParams(key)
+
+	plain := make([]byte, CRYPTO_CHUNK_SIZE*2+10)
+	data := encrypt(t, key, params, plain)
+
+	// 別の鍵
+	if _, err := NewDecryptReader(bytes.NewReader(data), testKey(), params, 0, -1); err == nil {
+		t.Errorf("decryption with a wrong key should fail")
+	}
+
+	// 改ざん
+	tampered := append([]byte{}, data...)
+	tampered[100] ^= 1
+	r, _ := NewDecryptReader(bytes.NewReader(tampered), key, params, 0, -1)
+	if _, err := ioutil.ReadAll(r); err == nil {
+		t.Errorf("tampered data should not be decrypted")
+	}
+
+	// チャンクの境界での切り詰め
+	truncated := data[:params.cipherChunkSize()*2]
+	r, _ = NewDecryptReader(bytes.NewReader(truncated), key, params, 0, -1)
+	if _, err := ioutil.ReadAll(r); err == nil {
+		t.Errorf("truncated data should not be decrypted")
+	}
+}
+
+func TestDecryptRange(t *testing.T) {
+	key := testKey()
+	params, _ := NewCryptoParams(key)
+
+	plain := make([]byte, CRYPTO_CHUNK_SIZE*4+500)
+	rand.Read(plain)
+	data := encrypt(t, key, params, plain)
+	size := int64(len(data))
+
+	ranges := [][2]int64{
+		{0, 10},
+		{CRYPTO_CHUNK_SIZE - 5, CRYPTO_CHUNK_SIZE + 5},
+		{CRYPTO_CHUNK_SIZE * 2, CRYPTO_CHUNK_SIZE*3 - 1},
+		{CRYPTO_CHUNK_SIZE*4 + 100, int64(len(plain)) - 1},
+	}
+
+	for _, rng := range ranges {
+		start, end := rng[0], rng[1]
+		cstart, cend, first, skip := params.CipherRange(start, end, size)
+
+		r, err := NewDecryptReader(bytes.NewReader(data[cstart:cend+1]), key, params, first, params.LastChunk(size))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decrypted, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Errorf("range %d-%d: %v", start, end, err)
+			continue
+		}
+
+		if !bytes.Equal(decrypted[skip:skip+end-start+1], plain[start:end+1]) {
+			t.Errorf("range %d-%d: decrypted data does not match", start, end)
+		}
+	}
+}
+
+func TestPassphraseKey(t *testing.T) {
+	key, err := NewPassphraseKey("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	derived, err := DerivePassphraseKey("secret", key.Kdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if derived.Id() != key.Id() {
+		t.Errorf("same passphrase and kdf should derive the same key")
+	}
+
+	other, _ := DerivePassphraseKey("wrong", key.Kdf)
+	if other.Id() == key.Id() {
+		t.Errorf("different passphrase should derive a different key")
+	}
+}
+
+func TestPbkdf2(t *testing.T) {
+	// PBKDF2-HMAC-SHA256の既知のテストベクタ
+	tests := []struct {
+		iter     int
+		expected string
+	}{
+		{1, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+		{2, "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43"},
+	}
+
+	for _, test := range tests {
+		key := pbkdf2([]byte("password"), []byte("salt"), test.iter, 32, sha256.New)
+		if hex.EncodeToString(key) != test.expected {
+			t.Errorf("iter %d: %x", test.iter, key)
+		}
+	}
+}
+
+func TestReadKeyFile(t *testing.T) {
+	file, err := ioutil.TempFile("", "conoha-ojs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+
+	file.WriteString("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f\n")
+	file.Close()
+
+	key, err := ReadKeyFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.key[31] != 0x1f {
+		t.Errorf("hex key file is not decoded")
+	}
+
+	ioutil.WriteFile(file.Name(), []byte("short"), 0600)
+	if _, err = ReadKeyFile(file.Name()); err == nil {
+		t.Errorf("invalid key file should be an error")
+	}
+}
@@ -0,0 +1,47 @@
+package lib
+
+import (
+	"path/filepath"
+)
+
+const (
+	DOWNLOAD_STATE_DIR = ".conoha-ojs-downloads"
+)
+
+// ダウンロードの途中経過
+// 途中まで書き込んだファイル(.part)の続きを、同じオブジェクトから取得するために使う
+type DownloadState struct {
+	// ダウンロード元のURL
+	Url string
+
+	// ダウンロードを開始した時点のETag
+	// 再開時にIf-Matchで指定して、オブジェクトが変更されていないことを確認する
+	Etag string
+}
+
+// 途中まで書き込んだファイルのパスから、途中経過を保存するファイルのパスを返す
+// ホームディレクトリの.conoha-ojs-downloads以下に保存される
+func DownloadStatePath(partPath string) (string, error) {
+	abs, err := filepath.Abs(partPath)
+	if err != nil {
+		return "", err
+	}
+
+	return stateFilePath(DOWNLOAD_STATE_DIR, abs)
+}
+
+// 途中経過をファイルから読み込む
+func (s *DownloadState) Read(path string) error {
+	return readStateFile(path, s)
+}
+
+// 途中経過をファイルに書き出す
+func (s *DownloadState) Save(path string) error {
+	return saveStateFile(path, s)
+}
+
+// 途中経過のファイルを削除する
+// ファイルが存在しない場合は何もしない
+func (s *DownloadState) Remove(path string) error {
+	return removeStateFile(path)
+}
@@ -0,0 +1,27 @@
+package lib
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestDownloadStatePath(t *testing.T) {
+	path1, err := DownloadStatePath("dir/file.part")
+	if err != nil {
+		t.Error(err)
+	}
+
+	path2, _ := DownloadStatePath("dir/file2.part")
+	if path1 == path2 {
+		t.Errorf("path should be different for each file")
+	}
+
+	path3, _ := DownloadStatePath("./dir/../dir/file.part")
+	if path1 != path3 {
+		t.Errorf("path should be the same for the same file")
+	}
+
+	if filepath.Base(filepath.Dir(path1)) != DOWNLOAD_STATE_DIR {
+		t.Errorf("wrong directory")
+	}
+}
@@ -0,0 +1,55 @@
+package lib
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// 拡張子とMIMEタイプの対応表
+// キーは先頭に.を含む小文字の拡張子とする
+type MimeTypes map[string]string
+
+// mime.types形式のファイルを読み込む
+//
+// 1行に「MIMEタイプ 拡張子 拡張子 ...」の形式で記述する。
+// 空行と#で始まる行は無視する。拡張子の先頭の.は省略できる。
+func ReadMimeTypes(path string) (MimeTypes, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	types := MimeTypes{}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		for _, ext := range fields[1:] {
+			types.Add(ext, fields[0])
+		}
+	}
+
+	return types, scanner.Err()
+}
+
+// 拡張子とMIMEタイプの対応を追加する
+func (t MimeTypes) Add(ext string, mimeType string) {
+	ext = strings.ToLower(ext)
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	t[ext] = mimeType
+}
+
+// 拡張子に対応するMIMEタイプを返す
+// 見つからない場合は空文字を返す
+func (t MimeTypes) TypeByExtension(ext string) string {
+	return t[strings.ToLower(ext)]
+}
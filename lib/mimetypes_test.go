@@ -0,0 +1,37 @@
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestReadMimeTypes(t *testing.T) {
+	file, err := ioutil.TempFile("", "conoha-ojs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+
+	file.WriteString("# comment\n\ntext/markdown md markdown\napplication/x-custom .CUS\n")
+	file.Close()
+
+	types, err := ReadMimeTypes(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		".md":       "text/markdown",
+		".markdown": "text/markdown",
+		".cus":      "application/x-custom",
+		".CUS":      "application/x-custom",
+		".txt":      "",
+	}
+
+	for ext, expected := range tests {
+		if types.TypeByExtension(ext) != expected {
+			t.Errorf("%s should be %s", ext, expected)
+		}
+	}
+}
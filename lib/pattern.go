@@ -0,0 +1,175 @@
+package lib
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// gitignoreと同じ形式のパターンのリスト
+//
+// 先頭が!のパターンは否定になり、後に書かれたパターンが優先される。
+// 末尾が/のパターンはディレクトリにのみマッチする。
+// 途中に/を含むパターンはルートからのパスに、含まないパターンはファイル名にマッチする。
+// ** は複数のディレクトリにマッチする。
+type Patterns struct {
+	patterns []*pattern
+}
+
+type pattern struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+func NewPatterns() *Patterns {
+	return &Patterns{}
+}
+
+// パターンを追加する
+// 空行と#で始まる行は無視する
+func (p *Patterns) Add(line string) error {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+
+	pat := &pattern{}
+
+	if strings.HasPrefix(line, "!") {
+		pat.negate = true
+		line = line[1:]
+	}
+
+	// 先頭の#や!をエスケープする場合
+	if strings.HasPrefix(line, `\#`) || strings.HasPrefix(line, `\!`) {
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		pat.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+
+	// 途中に/を含む場合はルートからのパスにマッチさせる
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	expr := globToRegexp(line)
+	if anchored {
+		expr = "^" + expr + "$"
+	} else {
+		expr = "^(.*/)?" + expr + "$"
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return err
+	}
+	pat.re = re
+
+	p.patterns = append(p.patterns, pat)
+	return nil
+}
+
+// ファイルからパターンを読み込む
+func (p *Patterns) ReadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		err = p.Add(scanner.Text())
+		if err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// パターンの数を返す
+func (p *Patterns) Len() int {
+	return len(p.patterns)
+}
+
+// パスがパターンにマッチするか調べる
+// パスはルートからの相対パスで、区切り文字は/とする
+// 親ディレクトリがマッチした場合も、マッチしたとみなす
+func (p *Patterns) Match(path string, isDir bool) bool {
+	elements := strings.Split(path, "/")
+	for i := 1; i < len(elements); i++ {
+		if p.match(strings.Join(elements[:i], "/"), true) {
+			return true
+		}
+	}
+
+	return p.match(path, isDir)
+}
+
+func (p *Patterns) match(path string, isDir bool) bool {
+	matched := false
+	for _, pat := range p.patterns {
+		if pat.dirOnly && !isDir {
+			continue
+		}
+
+		if pat.re.MatchString(path) {
+			matched = !pat.negate
+		}
+	}
+	return matched
+}
+
+// globを正規表現に変換する
+func globToRegexp(glob string) string {
+	var expr string
+
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			// 0個以上のディレクトリ
+			expr += "(.*/)?"
+			i += 2
+
+		case strings.HasPrefix(glob[i:], "**"):
+			expr += ".*"
+			i += 1
+
+		case c == '*':
+			expr += "[^/]*"
+
+		case c == '?':
+			expr += "[^/]"
+
+		case c == '[':
+			end := strings.Index(glob[i:], "]")
+			if end < 0 {
+				expr += regexp.QuoteMeta(string(c))
+				continue
+			}
+
+			class := glob[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr += "[" + strings.Replace(class, `\`, `\\`, -1) + "]"
+			i += end
+
+		case c == '\\' && i+1 < len(glob):
+			i++
+			expr += regexp.QuoteMeta(string(glob[i]))
+
+		default:
+			expr += regexp.QuoteMeta(string(c))
+		}
+	}
+
+	return expr
+}
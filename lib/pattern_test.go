@@ -0,0 +1,93 @@
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestPatternsMatch(t *testing.T) {
+	p := NewPatterns()
+
+	lines := []string{
+		"# comment",
+		"",
+		"*.swp",
+		".git/",
+		"node_modules",
+		"/build",
+		"docs/**/*.tmp",
+		"*.log",
+		"!important.log",
+	}
+	for _, line := range lines {
+		if err := p.Add(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		path    string
+		isDir   bool
+		matched bool
+	}{
+		{"main.go", false, false},
+		{".main.go.swp", false, true},
+		{"src/.main.go.swp", false, true},
+		{".git", true, true},
+		{".git/config", false, true},
+		{".git", false, false},
+		{"web/node_modules/pkg/index.js", false, true},
+		{"build", true, true},
+		{"src/build", true, false},
+		{"docs/a.tmp", false, true},
+		{"docs/a/b/c.tmp", false, true},
+		{"src/a.tmp", false, false},
+		{"debug.log", false, true},
+		{"important.log", false, false},
+	}
+
+	for _, test := range tests {
+		if p.Match(test.path, test.isDir) != test.matched {
+			t.Errorf("%s should be matched=%v", test.path, test.matched)
+		}
+	}
+}
+
+func TestPatternsCharacterClass(t *testing.T) {
+	p := NewPatterns()
+	p.Add("file[0-9].txt")
+	p.Add("data[!a].csv")
+
+	if !p.Match("file1.txt", false) || p.Match("fileA.txt", false) {
+		t.Errorf("character class is not working")
+	}
+
+	if !p.Match("datab.csv", false) || p.Match("dataa.csv", false) {
+		t.Errorf("negated character class is not working")
+	}
+}
+
+func TestPatternsReadFile(t *testing.T) {
+	file, err := ioutil.TempFile("", "conoha-ojs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+
+	file.WriteString("*.bak\n# comment\ntmp/\n")
+	file.Close()
+
+	p := NewPatterns()
+	if err = p.ReadFile(file.Name()); err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Len() != 2 {
+		t.Errorf("patterns should be 2")
+	}
+
+	if !p.Match("a/b.bak", false) {
+		t.Errorf("a/b.bak should be matched")
+	}
+}
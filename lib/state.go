@@ -0,0 +1,67 @@
+package lib
+
+// 中断した処理を再開するための途中経過を、JSONのファイルとして保存する
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/mitchellh/go-homedir"
+)
+
+// 途中経過を保存するファイルのパスを返す
+// ホームディレクトリのdir以下に、keyのハッシュをファイル名にして保存される
+func stateFilePath(dir string, key string) (string, error) {
+	homedir, err := homedir.Dir()
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha1.Sum([]byte(key))
+	name := hex.EncodeToString(sum[:]) + ".json"
+
+	return filepath.Join(homedir, dir, name), nil
+}
+
+// 途中経過をファイルから読み込む
+func readStateFile(path string, v interface{}) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	return decoder.Decode(v)
+}
+
+// 途中経過をファイルに書き出す
+// 他のユーザーから読めないように、ディレクトリとファイルは所有者のみにする
+func saveStateFile(path string, v interface{}) error {
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	return encoder.Encode(v)
+}
+
+// 途中経過のファイルを削除する
+// ファイルが存在しない場合は何もしない
+func removeStateFile(path string) error {
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStateFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conoha-ojs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state", "test.json")
+
+	type state struct {
+		Name  string
+		Count int
+	}
+	s := &state{Name: "test", Count: 3}
+
+	if err = saveStateFile(path, s); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("state file should be readable only by the owner, but %v", fi.Mode().Perm())
+	}
+
+	s2 := &state{}
+	if err = readStateFile(path, s2); err != nil {
+		t.Fatal(err)
+	}
+
+	if *s2 != *s {
+		t.Errorf("state should be the same after reading")
+	}
+
+	if err = removeStateFile(path); err != nil {
+		t.Error(err)
+	}
+
+	if err = removeStateFile(path); err != nil {
+		t.Errorf("removing a missing state should not be an error")
+	}
+}
@@ -0,0 +1,71 @@
+package lib
+
+import (
+	"os"
+)
+
+const (
+	UPLOAD_STATE_DIR = ".conoha-ojs-uploads"
+)
+
+// 分割アップロードの途中経過
+// アップロードが中断された場合に、この情報をもとに再開する
+type UploadState struct {
+	// アップロード元ファイルの情報
+	// ファイルが変更されていないかの判定に使う
+	Path    string
+	Size    int64
+	ModTime int64
+
+	// アップロード先
+	Container        string
+	Object           string
+	SegmentContainer string
+	SegmentPrefix    string
+	SegmentSize      int64
+
+	// アップロードが確認できたセグメントのETag
+	// インデックスがセグメント番号で、未完了のセグメントは空文字になる
+	Segments []string
+}
+
+// アップロード先のコンテナとオブジェクト名から、途中経過を保存するファイルのパスを返す
+// ホームディレクトリの.conoha-ojs-uploads以下に保存される
+func UploadStatePath(container string, object string) (string, error) {
+	return stateFilePath(UPLOAD_STATE_DIR, container+"/"+object)
+}
+
+// 途中経過をファイルから読み込む
+func (s *UploadState) Read(path string) error {
+	return readStateFile(path, s)
+}
+
+// 途中経過をファイルに書き出す
+func (s *UploadState) Save(path string) error {
+	return saveStateFile(path, s)
+}
+
+// 途中経過のファイルを削除する
+// ファイルが存在しない場合は何もしない
+func (s *UploadState) Remove(path string) error {
+	return removeStateFile(path)
+}
+
+// 途中経過が、指定されたファイルとセグメントサイズのものか調べる
+// ファイルの内容が変わっている場合は再開できない
+func (s *UploadState) Matches(path string, fi os.FileInfo, segmentSize int64) bool {
+	return s.Path == path &&
+		s.Size == fi.Size() &&
+		s.ModTime == fi.ModTime().UnixNano() &&
+		s.SegmentSize == segmentSize
+}
+
+// アップロードが完了したセグメント数を返す
+func (s *UploadState) Completed() (count int) {
+	for _, etag := range s.Segments {
+		if etag != "" {
+			count++
+		}
+	}
+	return count
+}
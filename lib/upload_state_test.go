@@ -0,0 +1,99 @@
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUploadStatePath(t *testing.T) {
+	path1, err := UploadStatePath("container", "object")
+	if err != nil {
+		t.Error(err)
+	}
+
+	path2, _ := UploadStatePath("container", "object2")
+	if path1 == path2 {
+		t.Errorf("path should be different for each object")
+	}
+
+	if filepath.Base(filepath.Dir(path1)) != UPLOAD_STATE_DIR {
+		t.Errorf("wrong directory")
+	}
+}
+
+func TestUploadStateSaveAndRead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conoha-ojs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state", "test.json")
+
+	s := &UploadState{
+		Path:        "test.dat",
+		Size:        300,
+		Container:   "container",
+		Object:      "test.dat",
+		SegmentSize: 100,
+		Segments:    []string{"etag1", "", "etag3"},
+	}
+
+	if err = s.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	s2 := &UploadState{}
+	if err = s2.Read(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if s2.Completed() != 2 {
+		t.Errorf("completed segments should be 2")
+	}
+
+	if err = s2.Remove(path); err != nil {
+		t.Error(err)
+	}
+
+	if err = s2.Remove(path); err != nil {
+		t.Errorf("removing a missing state should not be an error")
+	}
+}
+
+func TestUploadStateMatches(t *testing.T) {
+	file, err := ioutil.TempFile("", "conoha-ojs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+
+	file.WriteString("test data")
+	file.Close()
+
+	fi, _ := os.Stat(file.Name())
+
+	s := &UploadState{
+		Path:        file.Name(),
+		Size:        fi.Size(),
+		ModTime:     fi.ModTime().UnixNano(),
+		SegmentSize: 4,
+	}
+
+	if !s.Matches(file.Name(), fi, 4) {
+		t.Errorf("state should match the file")
+	}
+
+	if s.Matches(file.Name(), fi, 5) {
+		t.Errorf("state should not match with a different segment size")
+	}
+
+	ioutil.WriteFile(file.Name(), []byte("modified test data"), 0600)
+	fi, _ = os.Stat(file.Name())
+
+	if s.Matches(file.Name(), fi, 4) {
+		t.Errorf("state should not match the modified file")
+	}
+}